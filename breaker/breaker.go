@@ -1,8 +1,12 @@
 package breaker
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/junaozun/mango/clock"
 )
 
 type State int
@@ -13,12 +17,34 @@ const (
 	StateOpen
 )
 
+var (
+	ErrTooManyRequests = errors.New("too many requests") // 半开状态下请求数超过maxRequests
+	ErrOpenState       = errors.New("circuit breaker is open")
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
 type Counts struct {
 	Requests             int64  // 请求数量
 	TotalSuccess         uint64 // 总成功数
 	TotalFailures        uint32 // 总失败数
 	ConsecutiveSuccesses uint32 // 连续成功数
 	ConsecutiveFailures  uint32 // 连续失败数量
+	SlowCalls            uint32 // 慢调用数
+	WindowRequests       uint32 // 滑动窗口内的请求数，未启用窗口时等于当前这一代的请求结果数
+	WindowFailures       uint32 // 滑动窗口内的失败数
+	WindowSlowCalls      uint32 // 滑动窗口内的慢调用数
 }
 
 func (c *Counts) OnRequest() {
@@ -37,8 +63,28 @@ func (c *Counts) OnFail() {
 	c.ConsecutiveSuccesses = 0
 }
 
+func (c *Counts) OnSlow() {
+	c.SlowCalls++
+}
+
+// FailureRate 窗口内的失败率
+func (c Counts) FailureRate() float64 {
+	if c.WindowRequests == 0 {
+		return 0
+	}
+	return float64(c.WindowFailures) / float64(c.WindowRequests)
+}
+
+// SlowCallRate 窗口内的慢调用比例
+func (c Counts) SlowCallRate() float64 {
+	if c.WindowRequests == 0 {
+		return 0
+	}
+	return float64(c.WindowSlowCalls) / float64(c.WindowRequests)
+}
+
 func (c *Counts) Clear() {
-	c = new(Counts)
+	*c = Counts{}
 }
 
 type CircuitBreaker struct {
@@ -48,7 +94,18 @@ type CircuitBreaker struct {
 	timeout       time.Duration
 	readyToTrip   func(counts Counts) bool // 是否启用熔断
 	isSuccessful  func(err error) bool
-	onStateChange func(name string, form State, to State)
+	onStateChange func(name string, from State, to State)
+	minimumCalls  uint32
+	slowCall      time.Duration
+	window        window // 为nil时不启用滑动窗口
+	distributed   *Distributed
+	clusterPeriod time.Duration // 集群请求统计的周期
+	pending       bucket        // 还未上报到redis的请求统计
+	cluster       Counts        // 最近一次从redis拉取的集群统计
+	clusterFrom   int64         // 本地状态变更时所在的统计周期，只用之后周期的集群统计判断熔断
+	clusterTrip   bool          // 是否用集群统计判断熔断，默认的ReadyToTrip依赖连续失败次数，集群统计中没有
+	clock         clock.Clock
+	forced        bool // 被强制打开或关闭，保持当前状态直到Reset
 	mutex         sync.Mutex
 	state         State
 	generation    uint64
@@ -56,65 +113,495 @@ type CircuitBreaker struct {
 	expiry        time.Time
 }
 
+// Settings 熔断器配置，零值字段使用默认值
+type Settings struct {
+	Name          string
+	MaxRequests   uint32                                  // 半开状态下允许通过的最大请求数，默认1
+	Interval      time.Duration                           // closed状态下清空计数的周期，0表示不清空
+	Timeout       time.Duration                           // open状态持续多久后进入半开状态，默认20s
+	ReadyToTrip   func(counts Counts) bool                // closed状态下请求失败后调用，返回true则熔断
+	IsSuccessful  func(err error) bool                    // 判断请求是否成功，默认err == nil
+	OnStateChange func(name string, from State, to State) // 状态变更回调
+
+	WindowType       WindowType    // 滑动窗口类型，默认不启用
+	WindowSize       int           // 计数窗口统计的请求数；时间窗口的桶数量，默认10
+	WindowDuration   time.Duration // 时间窗口的总时长
+	MinimumCalls     uint32        // 窗口内请求数达到该值后才判断是否熔断
+	SlowCallDuration time.Duration // 请求耗时达到该值记为慢调用，0表示不统计慢调用
+
+	// Distributed 不为nil时通过redis在多个实例之间共享状态
+	// 集群统计只有请求数、失败数和慢调用数，需要配合FailureRateAbove等按比例判断的ReadyToTrip才能触发熔断，
+	// 使用默认ReadyToTrip时只同步熔断状态
+	Distributed *Distributed
+	Clock       clock.Clock // 时间来源，默认真实时间
+}
+
+const (
+	defaultMaxRequests         = 1
+	defaultTimeout             = time.Duration(20) * time.Second
+	defaultConsecutiveFailures = 5
+)
+
+func defaultReadyToTrip(counts Counts) bool {
+	return counts.ConsecutiveFailures > defaultConsecutiveFailures
+}
+
+func defaultIsSuccessful(err error) bool {
+	return err == nil
+}
+
 func NewCircuitBreaker(name string) *CircuitBreaker {
+	return NewCircuitBreakerWithSettings(Settings{Name: name})
+}
+
+func NewCircuitBreakerWithSettings(st Settings) *CircuitBreaker {
 	cb := &CircuitBreaker{
-		name:        name,
-		maxRequests: 1,
-		interval:    0,
-		timeout:     time.Duration(20) * time.Second,
-		readyToTrip: func(counts Counts) bool {
-			return counts.ConsecutiveFailures > 5
-		},
-		isSuccessful: func(err error) bool {
-			return err == nil
-		},
-		onStateChange: nil,
-		mutex:         sync.Mutex{},
-		state:         0,
-		generation:    0,
-		counts:        Counts{},
-		expiry:        time.Time{},
+		name:          st.Name,
+		maxRequests:   st.MaxRequests,
+		interval:      st.Interval,
+		timeout:       st.Timeout,
+		readyToTrip:   st.ReadyToTrip,
+		isSuccessful:  st.IsSuccessful,
+		onStateChange: st.OnStateChange,
+		minimumCalls:  st.MinimumCalls,
+		slowCall:      st.SlowCallDuration,
+		window:        newWindow(st),
+		distributed:   st.Distributed,
+		clock:         st.Clock,
+	}
+	if cb.clock == nil {
+		cb.clock = clock.New()
+	}
+	if cb.maxRequests == 0 {
+		cb.maxRequests = defaultMaxRequests
+	}
+	if cb.interval < 0 {
+		cb.interval = 0
+	}
+	if cb.timeout <= 0 {
+		cb.timeout = defaultTimeout
+	}
+	cb.clusterTrip = cb.readyToTrip != nil
+	if cb.readyToTrip == nil {
+		cb.readyToTrip = defaultReadyToTrip
+	}
+	if cb.isSuccessful == nil {
+		cb.isSuccessful = defaultIsSuccessful
 	}
 	cb.Generation()
+	cb.clusterPeriod = defaultCountsPeriod
+	if cb.interval > 0 {
+		cb.clusterPeriod = cb.interval
+	} else if st.WindowType == WindowTime && st.WindowDuration > 0 {
+		cb.clusterPeriod = st.WindowDuration
+	}
+	if cb.distributed != nil {
+		cb.distributed.register(cb)
+	}
 	return cb
 }
 
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+// State 返回当前状态
+func (cb *CircuitBreaker) State() State {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	state, _ := cb.currentState(cb.clock.Now())
+	return state
+}
+
+// Counts 返回当前这一代计数的快照，包含滑动窗口的统计
+func (cb *CircuitBreaker) Counts() Counts {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.snapshot(cb.clock.Now())
+}
+
+// Snapshot 熔断器某一时刻的完整状态
+type Snapshot struct {
+	Name       string
+	State      State
+	Generation uint64
+	Forced     bool
+	Counts     Counts
+}
+
+func (cb *CircuitBreaker) Snapshot() Snapshot {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	state, generation := cb.currentState(now)
+	return Snapshot{
+		Name:       cb.name,
+		State:      state,
+		Generation: generation,
+		Forced:     cb.forced,
+		Counts:     cb.snapshot(now),
+	}
+}
+
+// ForceOpen 强制打开，拒绝所有请求直到Reset
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.forced = true
+	cb.setState(StateOpen)
+}
+
+// ForceClose 强制关闭，放行所有请求且不再熔断，直到Reset
+func (cb *CircuitBreaker) ForceClose() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.forced = true
+	cb.setState(StateClosed)
+}
+
+// Reset 重置为closed状态并清空计数，同时解除强制状态
+func (cb *CircuitBreaker) Reset() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.forced = false
+	if cb.state == StateClosed {
+		cb.Generation()
+		cb.resetCluster()
+		return
+	}
+	cb.setState(StateClosed)
+}
+
+func (cb *CircuitBreaker) snapshot(now time.Time) Counts {
+	counts := cb.counts
+	if cb.window == nil {
+		counts.WindowRequests = uint32(counts.TotalSuccess) + counts.TotalFailures
+		counts.WindowFailures = counts.TotalFailures
+		counts.WindowSlowCalls = counts.SlowCalls
+		return counts
+	}
+	b := cb.window.snapshot(now)
+	counts.WindowRequests = b.requests
+	counts.WindowFailures = b.failures
+	counts.WindowSlowCalls = b.slowCalls
+	return counts
+}
+
+// Generation 开启新的一代，清空计数并根据当前状态重新计算过期时间
 func (cb *CircuitBreaker) Generation() {
 	cb.counts.Clear()
+	if cb.window != nil {
+		cb.window.reset()
+	}
 	cb.generation++
+	var zero time.Time
 	switch cb.state {
 	case StateClosed:
-		cb.expiry = time.Now().Add(cb.interval)
+		if cb.interval == 0 { // interval为0表示closed状态下不清空计数
+			cb.expiry = zero
+		} else {
+			cb.expiry = cb.clock.Now().Add(cb.interval)
+		}
 	case StateHalfOpen:
-		cb.expiry = time.Now()
+		cb.expiry = zero
 	case StateOpen:
-		cb.expiry = time.Now().Add(cb.timeout)
+		cb.expiry = cb.clock.Now().Add(cb.timeout)
 	}
 }
 
 func (cb *CircuitBreaker) Execute(req func() (any, error)) (any, error) {
 	// 请求之前判断是否执行熔断器
-	err := cb.beforeRequest()
+	generation, err := cb.beforeRequest()
 	if err != nil {
 		return nil, err
 	}
 	// 执行请求
+	start := cb.clock.Now()
+	defer func() {
+		// 请求panic记为失败后继续抛出
+		if e := recover(); e != nil {
+			cb.afterRequest(generation, false, cb.clock.Since(start))
+			panic(e)
+		}
+	}()
 	result, err := req()
-	cb.counts.OnRequest()
 
 	// 请求之后判断当前状态是否需要变更
-	err = cb.afterRequest(cb.isSuccessful(err))
-	return result, nil
+	cb.afterRequest(generation, cb.isSuccessful(err), cb.clock.Since(start))
+	return result, err
 }
 
-func (cb *CircuitBreaker) beforeRequest() error {
+// ExecuteContext 带context的Execute，ctx已经结束时直接返回ctx.Err()
+// 请求超过deadline记为失败，调用方主动取消不计入结果，panic记为失败后继续抛出
+func (cb *CircuitBreaker) ExecuteContext(ctx context.Context, req func(ctx context.Context) (any, error)) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+	start := cb.clock.Now()
+	defer func() {
+		if e := recover(); e != nil {
+			cb.afterRequest(generation, false, cb.clock.Since(start))
+			panic(e)
+		}
+	}()
+	result, err := req(ctx)
+
+	switch contextOutcome(ctx, err) {
+	case outcomeCanceled:
+		cb.cancelRequest(generation)
+	case outcomeDeadline:
+		cb.afterRequest(generation, false, cb.clock.Since(start))
+	default:
+		cb.afterRequest(generation, cb.isSuccessful(err), cb.clock.Since(start))
+	}
+	return result, err
+}
+
+// Allow 两步式调用，用于无法把请求包装成闭包的场景
+// 请求被放行时返回done回调，调用方需要在请求结束后通过done上报结果，重复调用done会被忽略
+// 请求耗时从Allow返回开始计算到调用done为止
+func (cb *CircuitBreaker) Allow() (done func(success bool), err error) {
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		return nil, err
+	}
+	start := cb.clock.Now()
+	var once sync.Once
+	return func(success bool) {
+		once.Do(func() {
+			cb.afterRequest(generation, success, cb.clock.Since(start))
+		})
+	}, nil
+}
+
+func (cb *CircuitBreaker) beforeRequest() (uint64, error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
 	// 判断当前状态，如果断路器打开状态，返回err
+	state, generation := cb.currentState(cb.clock.Now())
+	if state == StateOpen {
+		return generation, ErrOpenState
+	}
+	// 半开状态下只允许maxRequests个请求通过
+	if state == StateHalfOpen && cb.counts.Requests >= int64(cb.maxRequests) {
+		return generation, ErrTooManyRequests
+	}
+	cb.counts.OnRequest()
+	return generation, nil
 }
 
-func (cb *CircuitBreaker) afterRequest(isSuccess bool) error {
+func (cb *CircuitBreaker) afterRequest(before uint64, isSuccess bool, elapsed time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	now := cb.clock.Now()
+	state, generation := cb.currentState(now)
+	// 请求期间已经进入新的一代，结果不再计入
+	if generation != before {
+		return
+	}
+	slow := cb.slowCall > 0 && elapsed >= cb.slowCall
+	if slow {
+		cb.counts.OnSlow()
+	}
+	// redis不可用期间不累积，避免恢复后把故障期间的统计计入当前周期
+	if cb.distributed != nil && cb.distributed.alive() {
+		cb.pending.add(isSuccess, slow)
+	}
+	if cb.window != nil {
+		cb.window.add(now, isSuccess, slow)
+	}
 	if isSuccess {
-		cb.counts.OnSuccess()
+		cb.onSuccess(state, slow, now)
 	} else {
+		cb.onFail(state, now)
+	}
+}
+
+// cancelRequest 请求被调用方取消，不计入结果，归还半开状态下占用的请求名额
+func (cb *CircuitBreaker) cancelRequest(before uint64) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	_, generation := cb.currentState(cb.clock.Now())
+	if generation != before {
+		return
+	}
+	if cb.counts.Requests > 0 {
+		cb.counts.Requests--
+	}
+}
+
+func (cb *CircuitBreaker) onSuccess(state State, slow bool, now time.Time) {
+	switch state {
+	case StateClosed:
+		cb.counts.OnSuccess()
+		// 慢调用也可能达到熔断条件
+		if slow && cb.shouldTrip(now) {
+			cb.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		cb.counts.OnSuccess()
+		// 半开状态下连续成功maxRequests次，关闭熔断器
+		if cb.counts.ConsecutiveSuccesses >= cb.maxRequests {
+			cb.setState(StateClosed)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) onFail(state State, now time.Time) {
+	switch state {
+	case StateClosed:
 		cb.counts.OnFail()
+		if cb.shouldTrip(now) {
+			cb.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		// 半开状态下只要失败，重新打开熔断器
+		cb.setState(StateOpen)
+	}
+}
+
+// shouldTrip 窗口内请求数达到minimumCalls后，交给readyToTrip判断是否熔断
+func (cb *CircuitBreaker) shouldTrip(now time.Time) bool {
+	if cb.forced {
+		return false
+	}
+	counts := cb.snapshot(now)
+	if counts.WindowRequests < cb.minimumCalls {
+		return false
+	}
+	return cb.readyToTrip(counts)
+}
+
+// currentState 获取当前状态，过期时推进状态或开启新的一代
+func (cb *CircuitBreaker) currentState(now time.Time) (State, uint64) {
+	switch cb.state {
+	case StateClosed:
+		if !cb.expiry.IsZero() && cb.expiry.Before(now) {
+			cb.Generation()
+		}
+	case StateOpen:
+		if !cb.forced && cb.expiry.Before(now) {
+			cb.setState(StateHalfOpen)
+		}
+	}
+	return cb.state, cb.generation
+}
+
+func (cb *CircuitBreaker) setState(state State) {
+	if cb.state == state {
+		return
+	}
+	prev := cb.state
+	cb.state = state
+	cb.Generation()
+	cb.resetCluster()
+	if cb.distributed != nil {
+		cb.distributed.publish(cb, state, cb.expiry)
+	}
+	if cb.onStateChange != nil {
+		cb.onStateChange(cb.name, prev, state)
+	}
+}
+
+// ClusterCounts 返回最近一次从redis同步的集群请求统计，未启用分布式模式时为空
+func (cb *CircuitBreaker) ClusterCounts() Counts {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.cluster
+}
+
+// currentTransition 当前状态对应的状态转换，用于补发没有发布成功的状态
+func (cb *CircuitBreaker) currentTransition() transition {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	state, _ := cb.currentState(cb.clock.Now())
+	return transition{cb: cb, state: state, expiry: cb.expiry}
+}
+
+// clusterPeriodOf 时间t所在的集群统计周期，作为redis统计key的后缀
+func (cb *CircuitBreaker) clusterPeriodOf(t time.Time) int64 {
+	return t.UnixNano() / int64(cb.clusterPeriod)
+}
+
+// resetCluster 本地状态变更后丢弃集群统计，当前周期的统计包含变更前的失败，不能再用来判断熔断
+func (cb *CircuitBreaker) resetCluster() {
+	cb.cluster = Counts{}
+	cb.clusterFrom = cb.clusterPeriodOf(cb.clock.Now())
+}
+
+func (cb *CircuitBreaker) takePending() bucket {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	pending := cb.pending
+	cb.pending = bucket{}
+	return pending
+}
+
+// applyCluster 应用从redis同步到的集群统计和远端状态，period为集群统计所在的周期
+// 其他实例已经熔断时本地直接打开，不再重复发布；本地状态变更之后的周期的集群统计达到熔断条件时本地熔断并发布
+func (cb *CircuitBreaker) applyCluster(cluster Counts, period int64, remoteExpiry time.Time) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// 本地状态变更之前的周期的统计已经过时
+	stale := period <= cb.clusterFrom
+	if !stale {
+		cb.cluster = cluster
+	}
+	if cb.forced {
+		return
+	}
+	now := cb.clock.Now()
+	state, _ := cb.currentState(now)
+	if state == StateOpen {
+		return
+	}
+	if remoteExpiry.After(now) {
+		prev := cb.state
+		cb.state = StateOpen
+		cb.Generation()
+		cb.resetCluster()
+		cb.expiry = remoteExpiry
+		if cb.onStateChange != nil {
+			cb.onStateChange(cb.name, prev, StateOpen)
+		}
+		return
+	}
+	if state == StateClosed && cb.clusterTrip && !stale && cluster.WindowRequests >= cb.minimumCalls && cluster.WindowRequests > 0 && cb.readyToTrip(cluster) {
+		cb.setState(StateOpen)
+	}
+}
+
+type outcome int
+
+const (
+	outcomeDefault  outcome = iota // 由isSuccessful判断
+	outcomeDeadline                // 超过deadline，记为失败
+	outcomeCanceled                // 调用方取消，不计入结果
+)
+
+func contextOutcome(ctx context.Context, err error) outcome {
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+		return outcomeDeadline
+	}
+	if errors.Is(err, context.Canceled) || ctx.Err() == context.Canceled {
+		return outcomeCanceled
 	}
+	return outcomeDefault
 }
@@ -0,0 +1,468 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/junaozun/mango/clock"
+)
+
+var errReq = errors.New("req fail")
+
+func fail(cb *CircuitBreaker) error {
+	_, err := cb.Execute(func() (any, error) {
+		return nil, errReq
+	})
+	return err
+}
+
+func succeed(cb *CircuitBreaker) error {
+	_, err := cb.Execute(func() (any, error) {
+		return "ok", nil
+	})
+	return err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []State
+	clk := clock.NewFake(time.Now())
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name:    "test",
+		Timeout: 50 * time.Millisecond,
+		Clock:   clk,
+		OnStateChange: func(name string, from State, to State) {
+			changes = append(changes, to)
+		},
+	})
+
+	// 连续失败6次后熔断器打开
+	for i := 0; i < 6; i++ {
+		if err := fail(cb); err != errReq {
+			t.Fatalf("want request err, got %v", err)
+		}
+	}
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+	if err := succeed(cb); err != ErrOpenState {
+		t.Fatalf("want ErrOpenState, got %v", err)
+	}
+
+	// timeout之后进入半开状态，只放行maxRequests个请求
+	clk.Advance(60 * time.Millisecond)
+	generation, err := cb.beforeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.state != StateHalfOpen {
+		t.Fatalf("want half-open, got %s", cb.state)
+	}
+	if err := succeed(cb); err != ErrTooManyRequests {
+		t.Fatalf("want ErrTooManyRequests, got %v", err)
+	}
+	cb.afterRequest(generation, true, 0)
+	if cb.state != StateClosed {
+		t.Fatalf("want closed, got %s", cb.state)
+	}
+
+	want := []State{StateOpen, StateHalfOpen, StateClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("want changes %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("want changes %v, got %v", want, changes)
+		}
+	}
+}
+
+func TestCircuitBreakerInterval(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name:     "interval",
+		Interval: 30 * time.Millisecond,
+		Clock:    clk,
+	})
+	for i := 0; i < 5; i++ {
+		fail(cb)
+	}
+	clk.Advance(40 * time.Millisecond)
+	// interval过期后计数清空，不会因为累计失败而熔断
+	fail(cb)
+	if cb.State() != StateClosed {
+		t.Fatalf("want closed, got %s", cb.State())
+	}
+	if counts := cb.Counts(); counts.ConsecutiveFailures != 1 {
+		t.Fatalf("want 1 consecutive failure, got %d", counts.ConsecutiveFailures)
+	}
+}
+
+func TestCircuitBreakerAllow(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name: "allow",
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= 2
+		},
+	})
+	done, err := cb.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一个请求多次上报只计一次
+	done(false)
+	done(false)
+	if cb.State() != StateClosed {
+		t.Fatalf("want closed, got %s", cb.State())
+	}
+	if counts := cb.Counts(); counts.TotalFailures != 1 {
+		t.Fatalf("want 1 failure, got %d", counts.TotalFailures)
+	}
+
+	done, _ = cb.Allow()
+	done(false)
+	if _, err := cb.Allow(); err != ErrOpenState {
+		t.Fatalf("want ErrOpenState, got %v", err)
+	}
+}
+
+func TestCircuitBreakerCountWindow(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name:         "count-window",
+		WindowType:   WindowCount,
+		WindowSize:   10,
+		MinimumCalls: 10,
+		ReadyToTrip:  FailureRateAbove(0.5),
+	})
+	for i := 0; i < 4; i++ {
+		succeed(cb)
+	}
+	// 请求数没达到minimumCalls，失败率再高也不熔断
+	for i := 0; i < 5; i++ {
+		fail(cb)
+	}
+	if cb.State() != StateClosed {
+		t.Fatalf("want closed, got %s", cb.State())
+	}
+	fail(cb)
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreakerSlowCall(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name:             "slow-call",
+		Clock:            clk,
+		WindowType:       WindowTime,
+		WindowDuration:   time.Second,
+		MinimumCalls:     2,
+		SlowCallDuration: 10 * time.Millisecond,
+		ReadyToTrip:      SlowCallRateAbove(0.5),
+	})
+	succeed(cb)
+	cb.Execute(func() (any, error) {
+		clk.Advance(15 * time.Millisecond)
+		return nil, nil
+	})
+	// 成功但耗时过长的请求同样参与熔断判断
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+}
+
+func TestTimeWindowExpire(t *testing.T) {
+	w := newTimeWindow(time.Second, 10)
+	now := time.Unix(100, 0)
+	w.add(now, false, false)
+	w.add(now.Add(500*time.Millisecond), true, false)
+	if b := w.snapshot(now.Add(500 * time.Millisecond)); b.requests != 2 || b.failures != 1 {
+		t.Fatalf("want 2 requests 1 failure, got %+v", b)
+	}
+	// 超过窗口时长的桶不再统计
+	if b := w.snapshot(now.Add(1200 * time.Millisecond)); b.requests != 1 || b.failures != 0 {
+		t.Fatalf("want 1 request 0 failure, got %+v", b)
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager()
+	m.Override("b", Settings{MaxRequests: 3})
+	a := m.GetOrCreate("a", Settings{})
+	if a != m.GetOrCreate("a", Settings{MaxRequests: 5}) {
+		t.Fatal("want same breaker for same name")
+	}
+	b := m.GetOrCreate("b", Settings{MaxRequests: 5})
+	if b.maxRequests != 3 {
+		t.Fatalf("want override maxRequests 3, got %d", b.maxRequests)
+	}
+	// 已存在的熔断器不受覆盖配置影响
+	m.Override("a", Settings{MaxRequests: 7})
+	if got := m.GetOrCreate("a", Settings{}); got != a || got.maxRequests == 7 {
+		t.Fatal("want existing breaker kept after override")
+	}
+	list := m.List()
+	if len(list) != 2 || list[0].Name() != "a" || list[1].Name() != "b" {
+		t.Fatalf("unexpected list %v", list)
+	}
+
+	for i := 0; i < 6; i++ {
+		fail(a)
+	}
+	if a.State() != StateOpen {
+		t.Fatalf("want open, got %s", a.State())
+	}
+	m.ResetAll()
+	if a.State() != StateClosed {
+		t.Fatalf("want closed, got %s", a.State())
+	}
+}
+
+func TestCircuitBreakerApplyCluster(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name:         "cluster",
+		Timeout:      time.Second,
+		MinimumCalls: 10,
+		ReadyToTrip:  FailureRateAbove(0.5),
+		Clock:        clk,
+	})
+	period := cb.clusterPeriodOf(clk.Now())
+	tripping := Counts{WindowRequests: 10, WindowFailures: 6}
+	// 集群请求数不足minimumCalls时不熔断
+	cb.applyCluster(Counts{WindowRequests: 4, WindowFailures: 4}, period, time.Time{})
+	if cb.State() != StateClosed {
+		t.Fatalf("want closed, got %s", cb.State())
+	}
+	cb.applyCluster(tripping, period, time.Time{})
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+
+	// 半开探测成功后恢复，同一周期的统计包含熔断前的失败，不再重复熔断
+	clk.Advance(2 * time.Second)
+	done, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("want half-open probe allowed, got %v", err)
+	}
+	done(true)
+	if cb.State() != StateClosed {
+		t.Fatalf("want closed, got %s", cb.State())
+	}
+	cb.applyCluster(tripping, period, time.Time{})
+	if cb.State() != StateClosed {
+		t.Fatalf("want stale cluster counts ignored, got %s", cb.State())
+	}
+	next := cb.clusterPeriodOf(clk.Now()) + 1
+	cb.applyCluster(tripping, next, time.Time{})
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+
+	// 其他实例已经熔断，本地跟随打开直到远端过期时间
+	cb.Reset()
+	expiry := clk.Now().Add(time.Minute)
+	cb.applyCluster(Counts{}, next, expiry)
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+	if !cb.expiry.Equal(expiry) {
+		t.Fatalf("want expiry %v, got %v", expiry, cb.expiry)
+	}
+
+	// 默认ReadyToTrip依赖连续失败次数，不用集群统计判断
+	def := NewCircuitBreakerWithSettings(Settings{Name: "cluster-default", Clock: clk})
+	def.applyCluster(Counts{WindowRequests: 100, WindowFailures: 100, TotalFailures: 100}, next, time.Time{})
+	if def.State() != StateClosed {
+		t.Fatalf("want closed, got %s", def.State())
+	}
+}
+
+func TestDistributedRedisDown(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 50 * time.Millisecond,
+	})
+	defer client.Close()
+	clk := clock.NewFake(time.Now())
+	d := NewDistributedWithClock(client, 10*time.Millisecond, clk)
+	defer d.Close()
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name:        "redis-down",
+		Distributed: d,
+		Clock:       clk,
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= 3
+		},
+	})
+
+	// 推进到同步周期，同步失败后进入监控，标记redis不可用
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadUint32(&d.redisAlive) == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("want redis marked down")
+		}
+		clk.Advance(10 * time.Millisecond)
+		time.Sleep(time.Millisecond)
+	}
+
+	// 退化为本地熔断器，不再累积待上报的统计
+	for i := 0; i < 3; i++ {
+		fail(cb)
+	}
+	if cb.State() != StateOpen {
+		t.Fatalf("want open, got %s", cb.State())
+	}
+	if pending := cb.takePending(); pending.requests != 0 {
+		t.Fatalf("want no pending requests while redis down, got %d", pending.requests)
+	}
+	// 熔断状态没有发布出去，等redis恢复后补发
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		d.lock.Lock()
+		_, ok := d.unpublished[cb.Name()]
+		d.lock.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want open transition kept for republish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSreBreaker(t *testing.T) {
+	var b Breaker = NewSreBreaker(SreSettings{Name: "sre"})
+	for i := 0; i < 100; i++ {
+		if err := succeedBreaker(b); err != nil {
+			t.Fatalf("want no rejection while healthy, got %v", err)
+		}
+	}
+
+	sre := NewSreBreaker(SreSettings{Name: "sre-fail", K: 1})
+	for i := 0; i < 100; i++ {
+		sre.Execute(func() (any, error) {
+			return nil, errReq
+		})
+	}
+	if ratio := sre.DropRatio(); ratio < 0.5 {
+		t.Fatalf("want drop ratio >= 0.5 after failures, got %f", ratio)
+	}
+}
+
+func succeedBreaker(b Breaker) error {
+	_, err := b.Execute(func() (any, error) {
+		return "ok", nil
+	})
+	return err
+}
+
+func TestCircuitBreakerExecuteContext(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{Name: "context"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cb.ExecuteContext(context.Background(), func(context.Context) (any, error) {
+		return nil, ctx.Err()
+	})
+	if counts := cb.Counts(); counts.Requests != 0 || counts.TotalFailures != 0 {
+		t.Fatalf("want canceled request not counted, got %+v", counts)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := cb.ExecuteContext(ctx, func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	if counts := cb.Counts(); counts.TotalFailures != 1 {
+		t.Fatalf("want deadline counted as failure, got %+v", counts)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("want re-panic")
+			}
+		}()
+		cb.ExecuteContext(context.Background(), func(context.Context) (any, error) {
+			panic("boom")
+		})
+	}()
+	if counts := cb.Counts(); counts.TotalFailures != 2 {
+		t.Fatalf("want panic counted as failure, got %+v", counts)
+	}
+}
+
+func TestDoWithFallback(t *testing.T) {
+	cb := NewCircuitBreakerWithSettings(Settings{
+		Name: "do",
+		ReadyToTrip: func(counts Counts) bool {
+			return counts.ConsecutiveFailures >= 1
+		},
+	})
+	n, err := Do(cb, func() (int, error) {
+		return 1, nil
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("want 1, got %d %v", n, err)
+	}
+
+	fallback := func(err error) (int, error) {
+		return -1, nil
+	}
+	n, _ = DoWithFallback(cb, func() (int, error) {
+		return 0, errReq
+	}, fallback)
+	if n != -1 {
+		t.Fatalf("want fallback value, got %d", n)
+	}
+	// 熔断器已经打开，请求不会执行
+	n, _ = DoWithFallback(cb, func() (int, error) {
+		t.Fatal("request should not run while open")
+		return 0, nil
+	}, func(err error) (int, error) {
+		if err != ErrOpenState {
+			t.Fatalf("want ErrOpenState, got %v", err)
+		}
+		return -2, nil
+	})
+	if n != -2 {
+		t.Fatalf("want fallback value, got %d", n)
+	}
+}
+
+func TestManagerForceAndSubscribe(t *testing.T) {
+	m := NewManager()
+	events, cancel := m.Subscribe()
+	defer cancel()
+	clk := clock.NewFake(time.Now())
+	cb := m.GetOrCreate("force", Settings{Timeout: time.Millisecond, Clock: clk})
+
+	cb.ForceOpen()
+	e := <-events
+	if e.Name != "force" || e.From != StateClosed || e.To != StateOpen {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	// 强制打开后超过timeout也不会进入半开状态
+	clk.Advance(2 * time.Millisecond)
+	if s := cb.Snapshot(); s.State != StateOpen || !s.Forced {
+		t.Fatalf("want forced open, got %+v", s)
+	}
+
+	cb.Reset()
+	if e := <-events; e.To != StateClosed {
+		t.Fatalf("want closed event, got %+v", e)
+	}
+	if s := cb.Snapshot(); s.State != StateClosed || s.Forced {
+		t.Fatalf("want closed, got %+v", s)
+	}
+}
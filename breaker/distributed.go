@@ -0,0 +1,267 @@
+package breaker
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/junaozun/mango/clock"
+)
+
+const (
+	stateFormat         = "{%s}.breaker.state"
+	countsFormat        = "{%s}.breaker.counts.%d"
+	pingInterval        = time.Millisecond * 100
+	defaultSyncInterval = time.Millisecond * 500
+	defaultCountsPeriod = time.Second * 10
+	transitionBuffer    = 64
+)
+
+type transition struct {
+	cb     *CircuitBreaker
+	state  State
+	expiry time.Time
+}
+
+// Distributed 通过redis在多个实例之间共享熔断器的状态转换和请求统计
+// 一个实例熔断后其他实例同步打开，redis不可用时各实例退化为本地熔断器
+type Distributed struct {
+	client         *redis.Client
+	syncInterval   time.Duration
+	lock           sync.Mutex
+	breakers       map[string]*CircuitBreaker
+	unpublished    map[string]*CircuitBreaker // 状态转换没有发布成功的熔断器，下次同步时补发当前状态
+	transitions    chan transition
+	rescueLock     sync.Mutex
+	redisAlive     uint32
+	monitorStarted bool
+	once           sync.Once
+	closed         chan struct{}
+	clock          clock.Clock
+}
+
+// NewDistributed syncInterval为同步请求统计和远端状态的周期，<=0时使用默认值500ms
+func NewDistributed(client *redis.Client, syncInterval time.Duration) *Distributed {
+	return NewDistributedWithClock(client, syncInterval, clock.New())
+}
+
+// NewDistributedWithClock 指定同步和探测redis的时间来源，测试中可以传入clock.Fake
+func NewDistributedWithClock(client *redis.Client, syncInterval time.Duration, clk clock.Clock) *Distributed {
+	if syncInterval <= 0 {
+		syncInterval = defaultSyncInterval
+	}
+	d := &Distributed{
+		client:       client,
+		syncInterval: syncInterval,
+		breakers:     make(map[string]*CircuitBreaker),
+		unpublished:  make(map[string]*CircuitBreaker),
+		transitions:  make(chan transition, transitionBuffer),
+		redisAlive:   1,
+		closed:       make(chan struct{}),
+		clock:        clk,
+	}
+	go d.run()
+	return d
+}
+
+func (d *Distributed) register(cb *CircuitBreaker) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.breakers[cb.name] = cb
+}
+
+// publish 在熔断器锁内调用，不能阻塞，缓冲满了记为未发布，由下次同步补发
+func (d *Distributed) publish(cb *CircuitBreaker, state State, expiry time.Time) {
+	select {
+	case d.transitions <- transition{cb: cb, state: state, expiry: expiry}:
+	default:
+		d.markUnpublished(cb)
+	}
+}
+
+func (d *Distributed) markUnpublished(cb *CircuitBreaker) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.unpublished[cb.name] = cb
+}
+
+func (d *Distributed) Close() {
+	d.once.Do(func() {
+		close(d.closed)
+	})
+}
+
+func (d *Distributed) run() {
+	ticker := d.clock.NewTicker(d.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closed:
+			return
+		case t := <-d.transitions:
+			d.pushState(t)
+		case <-ticker.C():
+			d.syncAll()
+		}
+	}
+}
+
+func (d *Distributed) alive() bool {
+	return atomic.LoadUint32(&d.redisAlive) == 1
+}
+
+func (d *Distributed) pushState(t transition) {
+	if !d.alive() {
+		d.markUnpublished(t.cb)
+		return
+	}
+	key := fmt.Sprintf(stateFormat, t.cb.name)
+	var err error
+	switch t.state {
+	case StateOpen:
+		ttl := t.expiry.Sub(t.cb.clock.Now())
+		if ttl <= 0 {
+			return
+		}
+		err = d.client.Set(key, t.expiry.UnixNano(), ttl).Err()
+	case StateClosed:
+		err = d.client.Del(key).Err()
+	default:
+		// 半开状态由每个实例各自探测，不需要同步
+		return
+	}
+	if err != nil {
+		log.Printf("fail to publish breaker %s state: %s, use local state", t.cb.name, err)
+		d.markUnpublished(t.cb)
+		d.StartMonitor()
+	}
+}
+
+func (d *Distributed) syncAll() {
+	if !d.alive() {
+		return
+	}
+	d.lock.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(d.breakers))
+	for _, cb := range d.breakers {
+		breakers = append(breakers, cb)
+	}
+	unpublished := d.unpublished
+	d.unpublished = make(map[string]*CircuitBreaker)
+	d.lock.Unlock()
+	// 补发熔断器当前的状态，期间可能又发生了转换，不能重放之前丢失的那一次
+	for _, cb := range unpublished {
+		d.pushState(cb.currentTransition())
+	}
+	if !d.alive() {
+		return
+	}
+	for _, cb := range breakers {
+		if !d.sync(cb) {
+			return
+		}
+	}
+}
+
+// sync 上报本地新增的请求统计，拉取集群统计和远端状态
+func (d *Distributed) sync(cb *CircuitBreaker) bool {
+	pending := cb.takePending()
+	period := cb.clusterPeriodOf(cb.clock.Now())
+	countsKey := fmt.Sprintf(countsFormat, cb.name, period)
+	stateKey := fmt.Sprintf(stateFormat, cb.name)
+
+	var (
+		counts *redis.StringStringMapCmd
+		state  *redis.StringCmd
+	)
+	_, err := d.client.Pipelined(func(pipe redis.Pipeliner) error {
+		if pending.requests > 0 {
+			pipe.HIncrBy(countsKey, "requests", int64(pending.requests))
+			pipe.HIncrBy(countsKey, "failures", int64(pending.failures))
+			pipe.HIncrBy(countsKey, "slowCalls", int64(pending.slowCalls))
+			pipe.PExpire(countsKey, cb.clusterPeriod*2)
+		}
+		counts = pipe.HGetAll(countsKey)
+		state = pipe.Get(stateKey)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		log.Printf("fail to sync breaker %s: %s, use local state", cb.name, err)
+		d.StartMonitor()
+		return false
+	}
+
+	cluster := parseClusterCounts(counts.Val())
+	var remoteExpiry time.Time
+	if v, err := state.Int64(); err == nil {
+		remoteExpiry = time.Unix(0, v)
+	}
+	cb.applyCluster(cluster, period, remoteExpiry)
+	return true
+}
+
+// parseClusterCounts 集群统计按周期累加，没有连续成功和连续失败次数
+func parseClusterCounts(fields map[string]string) Counts {
+	get := func(field string) uint32 {
+		v, _ := strconv.ParseUint(fields[field], 10, 32)
+		return uint32(v)
+	}
+	requests, failures, slowCalls := get("requests"), get("failures"), get("slowCalls")
+	return Counts{
+		Requests:        int64(requests),
+		TotalSuccess:    uint64(requests - failures),
+		TotalFailures:   failures,
+		SlowCalls:       slowCalls,
+		WindowRequests:  requests,
+		WindowFailures:  failures,
+		WindowSlowCalls: slowCalls,
+	}
+}
+
+func (d *Distributed) StartMonitor() {
+	d.rescueLock.Lock()
+	defer d.rescueLock.Unlock()
+
+	if d.monitorStarted {
+		return
+	}
+
+	d.monitorStarted = true
+	atomic.StoreUint32(&d.redisAlive, 0)
+
+	go d.waitForRedis()
+}
+
+func (d *Distributed) waitForRedis() {
+	ticker := d.clock.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		d.rescueLock.Lock()
+		d.monitorStarted = false
+		d.rescueLock.Unlock()
+	}()
+
+	for {
+		select {
+		case <-d.closed:
+			return
+		case <-ticker.C():
+			if d.Ping() {
+				atomic.StoreUint32(&d.redisAlive, 1)
+				return
+			}
+		}
+	}
+}
+
+func (d *Distributed) Ping() bool {
+	v, err := d.client.Ping().Result()
+	if err != nil {
+		return false
+	}
+	return v == "PONG"
+}
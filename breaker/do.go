@@ -0,0 +1,20 @@
+package breaker
+
+// Do 泛型版本的Execute，省去调用方对结果的类型断言
+func Do[T any](b Breaker, req func() (T, error)) (T, error) {
+	result, err := b.Execute(func() (any, error) {
+		return req()
+	})
+	v, _ := result.(T)
+	return v, err
+}
+
+// DoWithFallback 请求被熔断器拒绝或者请求出错时调用fallback，返回缓存或默认值
+// fallback收到的err为ErrOpenState/ErrTooManyRequests或者请求返回的错误
+func DoWithFallback[T any](b Breaker, req func() (T, error), fallback func(err error) (T, error)) (T, error) {
+	v, err := Do(b, req)
+	if err != nil {
+		return fallback(err)
+	}
+	return v, nil
+}
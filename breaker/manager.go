@@ -0,0 +1,121 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// Manager 熔断器管理器，按名称共享熔断器，例如每个下游服务或每个路由一个
+type Manager struct {
+	lock        sync.Mutex
+	overrides   map[string]Settings        // name ---> 覆盖的配置
+	breakers    map[string]*CircuitBreaker // name ---> circuitBreaker
+	subLock     sync.Mutex
+	subID       int
+	subscribers map[int]chan StateChange
+}
+
+// StateChange 状态变更事件
+type StateChange struct {
+	Name string
+	From State
+	To   State
+}
+
+const subscriberBuffer = 16
+
+func NewManager() *Manager {
+	return &Manager{
+		overrides:   make(map[string]Settings),
+		breakers:    make(map[string]*CircuitBreaker),
+		subscribers: make(map[int]chan StateChange),
+	}
+}
+
+// GetOrCreate 获取名称对应的熔断器，不存在时使用st创建，如果设置了覆盖配置则优先使用覆盖配置
+func (m *Manager) GetOrCreate(name string, st Settings) *CircuitBreaker {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.breakers[name]
+	if ok {
+		return v
+	}
+	if override, ok := m.overrides[name]; ok {
+		st = override
+	}
+	st.Name = name
+	onStateChange := st.OnStateChange
+	st.OnStateChange = func(name string, from State, to State) {
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+		m.broadcast(StateChange{Name: name, From: from, To: to})
+	}
+	cb := NewCircuitBreakerWithSettings(st)
+	m.breakers[name] = cb
+	return cb
+}
+
+func (m *Manager) Get(name string) (*CircuitBreaker, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.breakers[name]
+	return v, ok
+}
+
+// Override 为指定名称设置覆盖配置，只作用于之后创建的熔断器
+// 已存在的熔断器可能已经被调用方持有，不会被替换
+func (m *Manager) Override(name string, st Settings) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.overrides[name] = st
+}
+
+// List 按名称排序返回所有熔断器
+func (m *Manager) List() []*CircuitBreaker {
+	m.lock.Lock()
+	list := make([]*CircuitBreaker, 0, len(m.breakers))
+	for _, cb := range m.breakers {
+		list = append(list, cb)
+	}
+	m.lock.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// ResetAll 重置所有熔断器
+func (m *Manager) ResetAll() {
+	for _, cb := range m.List() {
+		cb.Reset()
+	}
+}
+
+// Subscribe 订阅所有熔断器的状态变更事件，调用cancel取消订阅
+// 订阅方消费太慢时事件会被丢弃，不会阻塞熔断器
+func (m *Manager) Subscribe() (events <-chan StateChange, cancel func()) {
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	m.subID++
+	id := m.subID
+	ch := make(chan StateChange, subscriberBuffer)
+	m.subscribers[id] = ch
+	return ch, func() {
+		m.subLock.Lock()
+		defer m.subLock.Unlock()
+		delete(m.subscribers, id)
+	}
+}
+
+// broadcast 在熔断器锁内调用，不能阻塞
+func (m *Manager) broadcast(e StateChange) {
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
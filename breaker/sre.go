@@ -0,0 +1,158 @@
+package breaker
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/junaozun/mango/clock"
+)
+
+// Breaker CircuitBreaker和SreBreaker的公共接口，按依赖替换实现时调用方不用改动
+type Breaker interface {
+	Name() string
+	Execute(req func() (any, error)) (any, error)
+	ExecuteContext(ctx context.Context, req func(ctx context.Context) (any, error)) (any, error)
+	Allow() (done func(success bool), err error)
+}
+
+var (
+	_ Breaker = (*CircuitBreaker)(nil)
+	_ Breaker = (*SreBreaker)(nil)
+)
+
+const (
+	defaultSreK       = 1.5
+	defaultSreWindow  = time.Second * 10
+	defaultSreBuckets = 40
+)
+
+type SreSettings struct {
+	Name         string
+	K            float64              // 倍率，越小越激进，默认1.5
+	Window       time.Duration        // 滑动窗口时长，默认10s
+	Buckets      int                  // 窗口桶数量，默认40
+	IsSuccessful func(err error) bool // 判断请求是否成功，默认err == nil
+	Clock        clock.Clock          // 时间来源，默认真实时间
+}
+
+// SreBreaker Google SRE客户端自适应限流
+// 以概率 max(0, (requests - K*accepts) / (requests + 1)) 拒绝请求，没有固定的打开/关闭状态
+type SreBreaker struct {
+	name         string
+	k            float64
+	isSuccessful func(err error) bool
+	mutex        sync.Mutex
+	stat         *timeWindow
+	clock        clock.Clock
+	r            *rand.Rand
+}
+
+func NewSreBreaker(st SreSettings) *SreBreaker {
+	if st.K <= 0 {
+		st.K = defaultSreK
+	}
+	if st.Window <= 0 {
+		st.Window = defaultSreWindow
+	}
+	if st.Buckets <= 0 {
+		st.Buckets = defaultSreBuckets
+	}
+	if st.IsSuccessful == nil {
+		st.IsSuccessful = defaultIsSuccessful
+	}
+	if st.Clock == nil {
+		st.Clock = clock.New()
+	}
+	return &SreBreaker{
+		name:         st.Name,
+		k:            st.K,
+		isSuccessful: st.IsSuccessful,
+		stat:         newTimeWindow(st.Window, st.Buckets),
+		clock:        st.Clock,
+		r:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (b *SreBreaker) Name() string {
+	return b.name
+}
+
+func (b *SreBreaker) Execute(req func() (any, error)) (any, error) {
+	done, err := b.Allow()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			done(false)
+			panic(e)
+		}
+	}()
+	result, err := req()
+	done(b.isSuccessful(err))
+	return result, err
+}
+
+// ExecuteContext 语义与CircuitBreaker.ExecuteContext一致
+func (b *SreBreaker) ExecuteContext(ctx context.Context, req func(ctx context.Context) (any, error)) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	done, err := b.Allow()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			done(false)
+			panic(e)
+		}
+	}()
+	result, err := req(ctx)
+	switch contextOutcome(ctx, err) {
+	case outcomeCanceled:
+	case outcomeDeadline:
+		done(false)
+	default:
+		done(b.isSuccessful(err))
+	}
+	return result, err
+}
+
+// Allow 被拒绝时返回ErrOpenState，被拒绝的请求同样计入requests
+func (b *SreBreaker) Allow() (done func(success bool), err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.clock.Now()
+	if b.r.Float64() < b.dropRatio(now) {
+		b.stat.add(now, false, false)
+		return nil, ErrOpenState
+	}
+	var once sync.Once
+	return func(success bool) {
+		once.Do(func() {
+			b.mutex.Lock()
+			b.stat.add(b.clock.Now(), success, false)
+			b.mutex.Unlock()
+		})
+	}, nil
+}
+
+// DropRatio 当前的拒绝概率
+func (b *SreBreaker) DropRatio() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.dropRatio(b.clock.Now())
+}
+
+func (b *SreBreaker) dropRatio(now time.Time) float64 {
+	stat := b.stat.snapshot(now)
+	requests := float64(stat.requests)
+	accepts := float64(stat.requests - stat.failures)
+	return math.Max(0, (requests-b.k*accepts)/(requests+1))
+}
@@ -0,0 +1,164 @@
+package breaker
+
+import "time"
+
+type WindowType int
+
+const (
+	WindowNone  WindowType = iota // 不启用滑动窗口，只统计当前这一代
+	WindowCount                   // 基于请求数的滑动窗口，统计最近WindowSize次请求
+	WindowTime                    // 基于时间的滑动窗口，统计最近WindowDuration内的请求
+)
+
+const defaultWindowBuckets = 10
+
+// bucket 窗口内的统计单元
+type bucket struct {
+	requests  uint32
+	failures  uint32
+	slowCalls uint32
+}
+
+func (b *bucket) add(success, slow bool) {
+	b.requests++
+	if !success {
+		b.failures++
+	}
+	if slow {
+		b.slowCalls++
+	}
+}
+
+func (b *bucket) merge(o bucket) {
+	b.requests += o.requests
+	b.failures += o.failures
+	b.slowCalls += o.slowCalls
+}
+
+func (b *bucket) sub(o bucket) {
+	b.requests -= o.requests
+	b.failures -= o.failures
+	b.slowCalls -= o.slowCalls
+}
+
+type window interface {
+	add(now time.Time, success, slow bool)
+	snapshot(now time.Time) bucket
+	reset()
+}
+
+func newWindow(st Settings) window {
+	switch st.WindowType {
+	case WindowCount:
+		if st.WindowSize <= 0 {
+			return nil
+		}
+		return newCountWindow(st.WindowSize)
+	case WindowTime:
+		if st.WindowDuration <= 0 {
+			return nil
+		}
+		buckets := st.WindowSize
+		if buckets <= 0 {
+			buckets = defaultWindowBuckets
+		}
+		return newTimeWindow(st.WindowDuration, buckets)
+	default:
+		return nil
+	}
+}
+
+// countWindow 环形数组记录最近size次请求的结果
+type countWindow struct {
+	outcomes []bucket
+	next     int
+	total    bucket
+}
+
+func newCountWindow(size int) *countWindow {
+	return &countWindow{
+		outcomes: make([]bucket, size),
+	}
+}
+
+func (w *countWindow) add(_ time.Time, success, slow bool) {
+	// 覆盖最早的一次请求，先从总数中减掉
+	w.total.sub(w.outcomes[w.next])
+	w.outcomes[w.next] = bucket{}
+	w.outcomes[w.next].add(success, slow)
+	w.total.merge(w.outcomes[w.next])
+	w.next = (w.next + 1) % len(w.outcomes)
+}
+
+func (w *countWindow) snapshot(time.Time) bucket {
+	return w.total
+}
+
+func (w *countWindow) reset() {
+	for i := range w.outcomes {
+		w.outcomes[i] = bucket{}
+	}
+	w.next = 0
+	w.total = bucket{}
+}
+
+// timeWindow 把窗口时长切分成多个桶，每个桶统计一段时间内的请求，过期的桶不计入
+type timeWindow struct {
+	buckets []bucket
+	epochs  []int64 // 每个桶对应的时间片序号
+	span    int64   // 每个桶的时长(ns)
+}
+
+func newTimeWindow(d time.Duration, buckets int) *timeWindow {
+	span := int64(d) / int64(buckets)
+	if span <= 0 {
+		span = 1
+	}
+	return &timeWindow{
+		buckets: make([]bucket, buckets),
+		epochs:  make([]int64, buckets),
+		span:    span,
+	}
+}
+
+func (w *timeWindow) add(now time.Time, success, slow bool) {
+	epoch := now.UnixNano() / w.span
+	i := int(epoch % int64(len(w.buckets)))
+	if w.epochs[i] != epoch {
+		w.buckets[i] = bucket{}
+		w.epochs[i] = epoch
+	}
+	w.buckets[i].add(success, slow)
+}
+
+func (w *timeWindow) snapshot(now time.Time) bucket {
+	epoch := now.UnixNano() / w.span
+	var total bucket
+	for i := range w.buckets {
+		if epoch-w.epochs[i] < int64(len(w.buckets)) {
+			total.merge(w.buckets[i])
+		}
+	}
+	return total
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+		w.epochs[i] = 0
+	}
+}
+
+// FailureRateAbove 窗口内失败率达到rate(0~1)时熔断
+func FailureRateAbove(rate float64) func(counts Counts) bool {
+	return func(counts Counts) bool {
+		return counts.FailureRate() >= rate
+	}
+}
+
+// SlowCallRateAbove 窗口内慢调用比例达到rate(0~1)时熔断
+func SlowCallRateAbove(rate float64) func(counts Counts) bool {
+	return func(counts Counts) bool {
+		return counts.SlowCallRate() >= rate
+	}
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/junaozun/mango/breaker"
+)
+
+type BreakerConfig struct {
+	Breaker       breaker.Breaker
+	SlowThreshold time.Duration // 请求耗时超过该值记为失败，0表示不按耗时判断
+	Fallback      HandleFunc    // 熔断器打开时执行，默认返回503
+}
+
+// Breaker 熔断中间件，5xx状态码、panic以及超过SlowThreshold的请求都记为失败
+func Breaker(conf BreakerConfig) HandleFunc {
+	fallback := conf.Fallback
+	if fallback == nil {
+		fallback = func(c *Context) {
+			c.Fail(http.StatusServiceUnavailable, "Service Unavailable")
+		}
+	}
+	return func(c *Context) {
+		done, err := conf.Breaker.Allow()
+		if err != nil {
+			// 熔断中，不再执行后续handler
+			fallback(c)
+			return
+		}
+		start := time.Now()
+		defer func() {
+			// panic记为失败后继续抛出，交给Recovery处理
+			if err := recover(); err != nil {
+				done(false)
+				panic(err)
+			}
+		}()
+
+		c.Next()
+
+		success := c.StatusCode < http.StatusInternalServerError
+		if conf.SlowThreshold > 0 && time.Since(start) > conf.SlowThreshold {
+			success = false
+		}
+		done(success)
+	}
+}
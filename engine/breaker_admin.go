@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/junaozun/mango/breaker"
+)
+
+// RegisterBreakerAdmin 在rg下注册熔断器管理接口，例如 RegisterBreakerAdmin(r.Group("/debug/breakers"), mgr)
+//
+//	GET  /              列出所有熔断器的状态、代数和计数
+//	GET  /events        以text/event-stream推送状态变更事件
+//	POST /:name/open    强制打开
+//	POST /:name/close   强制关闭
+//	POST /:name/reset   重置并解除强制状态
+//
+// 接口本身不做鉴权，任何能访问的人都可以强制打开熔断器，rg必须先通过Use加上鉴权中间件，
+// 或者只注册在内网监听的引擎上
+func RegisterBreakerAdmin(rg *RouterGroup, m *breaker.Manager) {
+	rg.GET("", func(c *Context) {
+		list := m.List()
+		breakers := make([]H, 0, len(list))
+		for _, cb := range list {
+			breakers = append(breakers, snapshotH(cb.Snapshot()))
+		}
+		c.JSON(http.StatusOK, H{"breakers": breakers})
+	})
+	rg.GET("/events", func(c *Context) {
+		flusher, ok := c.W.(http.Flusher)
+		if !ok {
+			c.Fail(http.StatusInternalServerError, "streaming unsupported")
+			return
+		}
+		events, cancel := m.Subscribe()
+		defer cancel()
+		c.SetHeader("Content-Type", "text/event-stream")
+		c.SetHeader("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		flusher.Flush()
+		for {
+			select {
+			case <-c.R.Context().Done():
+				return
+			case e := <-events:
+				data, _ := json.Marshal(H{
+					"name": e.Name,
+					"from": e.From.String(),
+					"to":   e.To.String(),
+				})
+				fmt.Fprintf(c.W, "data: %s\n\n", data)
+				flusher.Flush()
+			}
+		}
+	})
+	rg.POST("/:name/open", breakerAction(m, (*breaker.CircuitBreaker).ForceOpen))
+	rg.POST("/:name/close", breakerAction(m, (*breaker.CircuitBreaker).ForceClose))
+	rg.POST("/:name/reset", breakerAction(m, (*breaker.CircuitBreaker).Reset))
+}
+
+func breakerAction(m *breaker.Manager, action func(cb *breaker.CircuitBreaker)) HandleFunc {
+	return func(c *Context) {
+		cb, ok := m.Get(c.Param("name"))
+		if !ok {
+			c.Fail(http.StatusNotFound, "breaker not found")
+			return
+		}
+		action(cb)
+		c.JSON(http.StatusOK, snapshotH(cb.Snapshot()))
+	}
+}
+
+func snapshotH(s breaker.Snapshot) H {
+	return H{
+		"name":       s.Name,
+		"state":      s.State.String(),
+		"generation": s.Generation,
+		"forced":     s.Forced,
+		"counts":     s.Counts,
+	}
+}
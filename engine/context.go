@@ -30,6 +30,7 @@ func NewContext() *Context {
 func (c *Context) flush() {
 	c.handlers = nil
 	c.index = -1
+	c.StatusCode = 0
 }
 
 func (c *Context) Next() {
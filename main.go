@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/go-redis/redis"
+	"github.com/junaozun/mango/breaker"
 	"github.com/junaozun/mango/engine"
 	"github.com/junaozun/mango/mgpool"
 	"github.com/junaozun/mango/tokenLimit"
@@ -85,13 +86,13 @@ func main() {
 			c.String(http.StatusOK, "hello %s, you're at %s\n", c.Param("name"), c.Path)
 		})
 	}
-	p, _ := mgpool.NewPool(5)
+	p, _ := mgpool.NewPoolWithFunc(5, func(arg any) {
+		time.Sleep(5 * time.Second)
+		log.Println("submit任务", arg)
+	})
 	r.ANY("/pool", func(c *engine.Context) {
 		c.HTML(http.StatusOK, "<h1>Index Page33333333</h1>")
-		p.Submit(func() {
-			time.Sleep(5 * time.Second)
-			log.Println("submit任务", rand.Int())
-		})
+		p.Invoke(rand.Int())
 	})
 	apiLimit := r.Group("api/limit")
 	{
@@ -99,6 +100,21 @@ func main() {
 			c.String(http.StatusOK, "api limit success")
 		})
 	}
+	breakerMgr := breaker.NewManager()
+	breakerGroup := r.Group("/breaker")
+	breakerGroup.Use(engine.Breaker(engine.BreakerConfig{
+		Breaker:       breakerMgr.GetOrCreate("breaker", breaker.Settings{}),
+		SlowThreshold: 2 * time.Second,
+	}))
+	{
+		breakerGroup.GET("/test1", func(c *engine.Context) {
+			if rand.Intn(2) == 0 {
+				c.String(http.StatusInternalServerError, "breaker fail")
+				return
+			}
+			c.String(http.StatusOK, "breaker success")
+		})
+	}
 
 	r.Run(":9999")
 }
@@ -0,0 +1,132 @@
+package mgpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+var ErrorNoFutures = errors.New("no futures to wait for")
+
+// Future 异步任务的结果
+type Future[T any] interface {
+	// Get 等待任务完成并返回结果，ctx先结束时返回ctx.Err()
+	Get(ctx context.Context) (T, error)
+	// Done 任务完成后关闭
+	Done() <-chan struct{}
+}
+
+// PanicError 任务panic时转换成的错误
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panic: %v", e.Value)
+}
+
+type future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func (f *future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func (f *future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// SubmitFunc 提交有返回值的任务，任务panic时Get返回*PanicError
+// 提交失败(例如pool已经释放)时返回的Future立即完成并携带提交错误
+func SubmitFunc[T any](p *Pool, fn func() (T, error)) Future[T] {
+	f := &future[T]{done: make(chan struct{})}
+	err := p.Submit(func() {
+		defer close(f.done)
+		defer func() {
+			if r := recover(); r != nil {
+				f.err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		f.value, f.err = fn()
+	})
+	if err != nil {
+		f.err = err
+		close(f.done)
+	}
+	return f
+}
+
+// All 等待所有任务完成，按顺序返回结果，任意一个任务出错时立即返回该错误
+func All[T any](ctx context.Context, futures ...Future[T]) ([]T, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	ready := whenDone(futures, stop)
+	results := make([]T, len(futures))
+	for range futures {
+		select {
+		case i := <-ready:
+			v, err := futures[i].Get(ctx)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = v
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// Any 返回最先成功完成的任务结果，全部失败时返回最后一个错误，没有传入future时返回ErrorNoFutures
+func Any[T any](ctx context.Context, futures ...Future[T]) (T, error) {
+	if len(futures) == 0 {
+		var zero T
+		return zero, ErrorNoFutures
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	ready := whenDone(futures, stop)
+	var (
+		zero    T
+		lastErr error
+	)
+	for range futures {
+		select {
+		case i := <-ready:
+			v, err := futures[i].Get(ctx)
+			if err == nil {
+				return v, nil
+			}
+			lastErr = err
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+	return zero, lastErr
+}
+
+// whenDone 按完成顺序返回future的下标，stop关闭后不再等待
+func whenDone[T any](futures []Future[T], stop <-chan struct{}) <-chan int {
+	ready := make(chan int, len(futures))
+	for i, f := range futures {
+		go func(i int, f Future[T]) {
+			select {
+			case <-f.Done():
+				ready <- i
+			case <-stop:
+			}
+		}(i, f)
+	}
+	return ready
+}
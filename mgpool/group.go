@@ -0,0 +1,59 @@
+package mgpool
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+)
+
+// Group 类似errgroup，任务都在pool的worker上执行，并发数受pool容量限制
+// 任意一个任务返回错误后派生的ctx被取消，Wait返回第一个错误
+type Group struct {
+	pool    *Pool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup 返回Group和派生的ctx，ctx在第一个任务出错或者Wait返回时取消
+func NewGroup(ctx context.Context, p *Pool) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{pool: p, ctx: ctx, cancel: cancel}, ctx
+}
+
+// Go 提交任务，pool没有可用worker时阻塞等待，ctx取消后不再提交
+// 提交失败的错误和任务的panic(转换为*PanicError)同样作为任务错误
+func (g *Group) Go(f func(ctx context.Context) error) {
+	g.wg.Add(1)
+	err := g.pool.SubmitContext(g.ctx, func() {
+		defer g.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				g.setErr(&PanicError{Value: r, Stack: debug.Stack()})
+			}
+		}()
+		if err := f(g.ctx); err != nil {
+			g.setErr(err)
+		}
+	})
+	if err != nil {
+		g.wg.Done()
+		g.setErr(err)
+	}
+}
+
+// Wait 等待所有任务完成，返回第一个错误
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+func (g *Group) setErr(err error) {
+	g.errOnce.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
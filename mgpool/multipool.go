@@ -0,0 +1,126 @@
+package mgpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// LoadBalancingStrategy MultiPool选择子pool的策略
+type LoadBalancingStrategy int
+
+const (
+	RoundRobin LoadBalancingStrategy = iota // 轮询
+	LeastTasks                              // 正在执行任务的worker最少(可接收任务最多)的子pool
+)
+
+var ErrorInValidStrategy = errors.New("invalid load balancing strategy")
+
+// MultiPool 由多个子pool组成，提交任务时按策略选择一个子pool，分散单个pool锁的竞争
+type MultiPool struct {
+	pools []*Pool
+	index uint32
+	lbs   LoadBalancingStrategy
+}
+
+// NewMultiPool 创建size个子pool，每个子pool容量为capPerPool，options作用于所有子pool
+func NewMultiPool(size, capPerPool int, lbs LoadBalancingStrategy, options ...Option) (*MultiPool, error) {
+	if size <= 0 {
+		return nil, ErrorInValidCap
+	}
+	if lbs != RoundRobin && lbs != LeastTasks {
+		return nil, ErrorInValidStrategy
+	}
+	pools := make([]*Pool, size)
+	for i := range pools {
+		p, err := New(capPerPool, options...)
+		if err != nil {
+			for _, created := range pools[:i] {
+				created.Release()
+			}
+			return nil, err
+		}
+		pools[i] = p
+	}
+	return &MultiPool{pools: pools, lbs: lbs}, nil
+}
+
+func (mp *MultiPool) next() *Pool {
+	switch mp.lbs {
+	case LeastTasks:
+		// 存活的worker包括空闲的，不能反映负载，按还能接收的任务数量选择
+		least, free := mp.pools[0], mp.pools[0].FreeWorkerCount()
+		for _, p := range mp.pools[1:] {
+			if n := p.FreeWorkerCount(); n > free {
+				least, free = p, n
+			}
+		}
+		return least
+	default:
+		i := atomic.AddUint32(&mp.index, 1) - 1
+		return mp.pools[i%uint32(len(mp.pools))]
+	}
+}
+
+func (mp *MultiPool) Submit(task func()) error {
+	return mp.next().Submit(task)
+}
+
+func (mp *MultiPool) SubmitContext(ctx context.Context, task func()) error {
+	return mp.next().SubmitContext(ctx, task)
+}
+
+// Cap 所有子pool的容量之和
+func (mp *MultiPool) Cap() int {
+	var n int
+	for _, p := range mp.pools {
+		n += p.Cap()
+	}
+	return n
+}
+
+func (mp *MultiPool) RunningWorkerCount() int {
+	var n int
+	for _, p := range mp.pools {
+		n += p.RunningWorkerCount()
+	}
+	return n
+}
+
+func (mp *MultiPool) FreeWorkerCount() int {
+	var n int
+	for _, p := range mp.pools {
+		n += p.FreeWorkerCount()
+	}
+	return n
+}
+
+func (mp *MultiPool) IsClosed() bool {
+	return mp.pools[0].IsClosed()
+}
+
+func (mp *MultiPool) Release() {
+	for _, p := range mp.pools {
+		p.Release()
+	}
+}
+
+// ReleaseTimeout 释放所有子pool，timeout为等待所有子pool的总时长
+func (mp *MultiPool) ReleaseTimeout(timeout time.Duration) error {
+	clk := mp.pools[0].clock // 子pool使用相同的选项创建，时间来源一致
+	deadline := clk.Now().Add(timeout)
+	mp.Release()
+	for _, p := range mp.pools {
+		if err := p.ReleaseTimeout(deadline.Sub(clk.Now())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mp *MultiPool) Reboot() {
+	for _, p := range mp.pools {
+		p.Reboot()
+	}
+}
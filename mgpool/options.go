@@ -0,0 +1,88 @@
+package mgpool
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/junaozun/mango/clock"
+)
+
+// Logger pool内部使用的日志接口，*log.Logger满足该接口
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+var defaultLogger Logger = log.New(os.Stderr, "", log.LstdFlags)
+
+type Options struct {
+	Expiry           time.Duration // 空闲worker的过期时间
+	PreAlloc         bool          // 是否预先按cap分配空闲队列
+	Nonblocking      bool
+	MaxBlockingTasks int
+	PanicHandler     func(p any, stack []byte)
+	Logger           Logger
+	Clock            clock.Clock
+}
+
+type Option func(opts *Options)
+
+func loadOptions(options ...Option) *Options {
+	opts := &Options{
+		Expiry: DefaultExpire * time.Second,
+		Logger: defaultLogger,
+		Clock:  clock.New(),
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+// WithExpiry 空闲worker超过expiry没有任务会被清理
+func WithExpiry(expiry time.Duration) Option {
+	return func(opts *Options) {
+		opts.Expiry = expiry
+	}
+}
+
+// WithPreAlloc 创建pool时按cap预先分配空闲队列，避免运行时扩容
+func WithPreAlloc(preAlloc bool) Option {
+	return func(opts *Options) {
+		opts.PreAlloc = preAlloc
+	}
+}
+
+// WithNonblocking 没有可用worker时Submit直接返回ErrPoolOverload
+func WithNonblocking(nonblocking bool) Option {
+	return func(opts *Options) {
+		opts.Nonblocking = nonblocking
+	}
+}
+
+// WithMaxBlockingTasks 阻塞等待worker的调用方上限
+func WithMaxBlockingTasks(maxBlockingTasks int) Option {
+	return func(opts *Options) {
+		opts.MaxBlockingTasks = maxBlockingTasks
+	}
+}
+
+func WithPanicHandler(panicHandler func(p any, stack []byte)) Option {
+	return func(opts *Options) {
+		opts.PanicHandler = panicHandler
+	}
+}
+
+// WithLogger 替换默认的标准库日志
+func WithLogger(logger Logger) Option {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
+// WithClock 替换时间来源，测试中可以传入clock.Fake控制worker过期
+func WithClock(clk clock.Clock) Option {
+	return func(opts *Options) {
+		opts.Clock = clk
+	}
+}
@@ -1,62 +1,116 @@
 package mgpool
 
 import (
+	"context"
 	"errors"
-	"log"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/junaozun/mango/clock"
 )
 
-const DefaultExpire = 5
+const (
+	DefaultExpire       = 5
+	releasePollInterval = 10 * time.Millisecond
+)
 
 var (
-	ErrorInValidCap    = errors.New("pool cap can not <= 0")
-	ErrorInValidExpire = errors.New("pool expire can not <= 0")
-	ErrorPoolHasClosed = errors.New("pool has bean released")
+	ErrorInValidCap     = errors.New("pool cap can not <= 0")
+	ErrorInValidExpire  = errors.New("pool expire can not <= 0")
+	ErrorPoolHasClosed  = errors.New("pool has bean released")
+	ErrorReleaseTimeout = errors.New("pool release timed out")
+	ErrorLackPoolFunc   = errors.New("must provide function for pool")
+	ErrPoolOverload     = errors.New("too many goroutines blocked on submit or Nonblocking is set")
 )
 
 type Pool struct {
-	workers      []*Worker     // 空闲worker
-	cap          int32         // pool max cap
-	running      int32         // 正在运行的worker数量
-	expire       time.Duration // 过期时间，空闲的worker超过这个时间回收掉
-	release      chan sig      // 释放资源，pool就不能使用了
-	lock         sync.Mutex    // 保护pool里相关资源的安全
-	once         sync.Once     // 释放只能调用一次，不能多次调用
-	workerCache  sync.Pool     //workerCache 缓存
-	cond         *sync.Cond
-	PanicHandler func()
+	workers     *workerStack  // 空闲worker
+	cap         int32         // pool max cap
+	running     int32         // 正在运行的worker数量
+	expire      time.Duration // 过期时间，空闲的worker超过这个时间回收掉
+	state       int32         // pool状态，stateOpened/stateClosed，通过CAS切换
+	lock        sync.Mutex    // 保护pool里相关资源的安全
+	stopClear   chan sig      // 关闭时停止清理过期worker的goroutine，nil表示清理goroutine已经停止
+	workerCache sync.Pool     //workerCache 缓存
+	cond        *sync.Cond
+	clock       clock.Clock
+	logger      Logger
+	fn          func(arg any) // 不为nil时为PoolWithFunc，worker用arg调用fn
+	blocking    int           // 阻塞等待worker的调用方数量
+	metrics     metrics
+	// PanicHandler 任务panic时调用，p为recover的值，stack为panic时的调用栈，包含任务函数所在的帧
+	// 未设置时打印日志，panic的worker随后退出
+	PanicHandler func(p any, stack []byte)
+	// Nonblocking 为true时没有可用worker直接返回ErrPoolOverload，不阻塞等待
+	Nonblocking bool
+	// MaxBlockingTasks 阻塞等待worker的调用方上限，超过时返回ErrPoolOverload，0表示不限制
+	MaxBlockingTasks int
 }
 
 type sig struct {
 }
 
+const (
+	stateOpened int32 = iota
+	stateClosed
+)
+
 func NewPool(cap int) (*Pool, error) {
 	return NewTimePool(cap, DefaultExpire)
 }
 
 func NewTimePool(cap int, expire int) (*Pool, error) {
+	return New(cap, WithExpiry(time.Duration(expire)*time.Second))
+}
+
+// New 创建pool，未指定的选项使用默认值：空闲worker 5s过期，阻塞等待worker
+func New(cap int, options ...Option) (*Pool, error) {
+	return newPool(cap, nil, options...)
+}
+
+func newPool(cap int, fn func(arg any), options ...Option) (*Pool, error) {
 	if cap <= 0 {
 		return nil, ErrorInValidCap
 	}
-	if expire <= 0 {
+	opts := loadOptions(options...)
+	if opts.Expiry <= 0 {
 		return nil, ErrorInValidExpire
 	}
+	if opts.Logger == nil {
+		opts.Logger = defaultLogger
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
 	p := &Pool{
-		cap:     int32(cap),
-		expire:  time.Duration(expire) * time.Second,
-		release: make(chan sig, 1),
-		lock:    sync.Mutex{},
+		cap:              int32(cap),
+		expire:           opts.Expiry,
+		stopClear:        make(chan sig),
+		lock:             sync.Mutex{},
+		clock:            opts.Clock,
+		logger:           opts.Logger,
+		fn:               fn,
+		PanicHandler:     opts.PanicHandler,
+		Nonblocking:      opts.Nonblocking,
+		MaxBlockingTasks: opts.MaxBlockingTasks,
+	}
+	if opts.PreAlloc {
+		p.workers = newWorkerStack(cap)
+	} else {
+		p.workers = newWorkerStack(0)
 	}
 	p.workerCache.New = func() any {
-		return &Worker{
-			pool: p,
-			task: make(chan func(), 1),
+		w := &Worker{pool: p}
+		if fn != nil {
+			w.args = make(chan any, 1)
+		} else {
+			w.task = make(chan func(), 1)
 		}
+		return w
 	}
 	p.cond = sync.NewCond(&p.lock)
-	go p.clearExpireWorker() //定时清理过期的空闲worker
+	go p.clearExpireWorker(p.stopClear) //定时清理过期的空闲worker
 	return p, nil
 }
 
@@ -68,121 +122,229 @@ func (p *Pool) decRunning() {
 	atomic.AddInt32(&p.running, -1)
 }
 
-func (p *Pool) PutWorker(w *Worker) {
+// PutWorker 任务执行完后worker放回空闲队列，等待下一个任务
+// pool已经释放时返回false，worker随之退出
+func (p *Pool) PutWorker(w *Worker) bool {
 	p.lock.Lock()
-	w.lastTime = time.Now()
-	p.workers = append(p.workers, w)
-	w.pool.workerCache.Put(w)
+	if p.IsClosed() {
+		p.lock.Unlock()
+		return false
+	}
+	// 缩容后运行的worker超过cap，多余的worker直接退出
+	if p.RunningWorkerCount() > p.Cap() {
+		p.lock.Unlock()
+		return false
+	}
+	w.lastTime = p.clock.Now()
+	p.workers.insert(w)
 	p.cond.Signal() // 通知goroutine已经有worker放回pool中
 	p.lock.Unlock()
-	w.pool.decRunning()
+	return true
 }
 
 // Submit 提交任务
 func (p *Pool) Submit(task func()) error {
-	if len(p.release) > 0 {
-		return ErrorPoolHasClosed
+	return p.SubmitContext(context.Background(), task)
+}
+
+// SubmitContext 提交任务，没有可用worker时最多等待到ctx结束，返回ctx.Err()
+func (p *Pool) SubmitContext(ctx context.Context, task func()) error {
+	// 从pool中获取一个worker，然后把任务交给它执行
+	w, err := p.acquire(ctx)
+	if err != nil {
+		return err
 	}
-	// 从pool中获取一个worker，然后执行任务
-	w := p.GetWorker()
 	w.task <- task
-	w.run()
 	return nil
 }
 
-func (p *Pool) GetWorker() (w *Worker) {
+// acquire 获取worker并记录等待耗时和提交结果
+func (p *Pool) acquire(ctx context.Context) (*Worker, error) {
+	start := p.clock.Now()
+	w, err := p.getWorker(ctx)
+	if err != nil {
+		atomic.AddUint64(&p.metrics.rejected, 1)
+		return nil, err
+	}
+	p.metrics.queueWait.observe(p.clock.Since(start))
+	atomic.AddUint64(&p.metrics.submitted, 1)
+	return w, nil
+}
+
+// GetWorker 获取一个worker，优先复用空闲的worker
+// 没有可用worker时阻塞等待，Nonblocking或者等待的调用方超过MaxBlockingTasks时返回ErrPoolOverload
+func (p *Pool) GetWorker() (w *Worker, err error) {
+	return p.getWorker(context.Background())
+}
+
+func (p *Pool) getWorker(ctx context.Context) (w *Worker, err error) {
+	var stop chan struct{}
+	defer func() {
+		if stop != nil {
+			close(stop)
+		}
+	}()
 	p.lock.Lock()
-	idleWorkers := p.workers
-	n := len(idleWorkers) - 1
-	if n >= 0 { // 说明存在空闲的worker
-		w = idleWorkers[n]   // 取最后一个worker
-		idleWorkers[n] = nil // 置空，防止内存泄漏
-		p.workers = idleWorkers[:n]
+	defer p.lock.Unlock()
+	for {
+		if p.IsClosed() {
+			return nil, ErrorPoolHasClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if w = p.workers.detach(); w != nil { // 说明存在空闲的worker
+			return w, nil
+		}
+		// 如果没有空闲的worker，判断一下正在运行worker数量，如果小于cap容量，新建一个
+		if p.RunningWorkerCount() < p.Cap() {
+			w = p.workerCache.Get().(*Worker)
+			w.run()
+			return w, nil
+		}
+		if p.Nonblocking {
+			return nil, ErrPoolOverload
+		}
+		if p.MaxBlockingTasks > 0 && p.blocking >= p.MaxBlockingTasks {
+			return nil, ErrPoolOverload
+		}
+		// ctx结束时唤醒等待的goroutine，cond.Wait本身不感知ctx
+		if stop == nil && ctx.Done() != nil {
+			stop = make(chan struct{})
+			go p.wakeOnDone(ctx, stop)
+		}
+		// 如果大于等于cap容量，阻塞等待worker放回pool或者退出
+		p.blocking++
+		p.cond.Wait()
+		p.blocking--
+	}
+}
+
+func (p *Pool) wakeOnDone(ctx context.Context, stop chan struct{}) {
+	select {
+	case <-ctx.Done():
+		p.lock.Lock()
+		p.cond.Broadcast()
 		p.lock.Unlock()
+	case <-stop:
+	}
+}
+
+// Release 关闭pool，只有第一次调用生效
+func (p *Pool) Release() {
+	if !atomic.CompareAndSwapInt32(&p.state, stateOpened, stateClosed) {
 		return
 	}
-	// 如果没有空闲的worker，要新建这个worker
-	// 判断一下正在运行worker数量，如果小于cap容量，新建一个
-	if p.running < p.cap {
+	p.lock.Lock()
+	// 加锁前可能已经被Reboot重新开启，此时不再清理
+	if !p.IsClosed() {
 		p.lock.Unlock()
-		w = p.workerCache.Get().(*Worker)
-		//w.task = make(chan func(), 1)
 		return
 	}
-	// 如果大于等于cap容量，阻塞等待worker释放
+	if p.stopClear != nil {
+		close(p.stopClear)
+		p.stopClear = nil
+	}
+	workers := p.workers.reset()
+	p.cond.Broadcast() // 唤醒等待worker的goroutine
 	p.lock.Unlock()
-	return p.waitIdleWorker()
+	for _, w := range workers {
+		w.stop() // 通知空闲worker退出，正在执行任务的worker执行完后退出
+	}
 }
 
-func (p *Pool) waitIdleWorker() *Worker {
+// PanicCount panic的任务数量
+func (p *Pool) PanicCount() int {
+	return int(atomic.LoadUint64(&p.metrics.panicked))
+}
+
+func (p *Pool) Cap() int {
+	return int(atomic.LoadInt32(&p.cap))
+}
+
+// Tune 运行时调整pool容量，扩容时唤醒等待worker的goroutine，缩容时多余的空闲worker退出，
+// 正在执行任务的worker在任务完成后退出
+func (p *Pool) Tune(newCap int) {
+	oldCap := p.Cap()
+	if newCap <= 0 || newCap == oldCap {
+		return
+	}
+	atomic.StoreInt32(&p.cap, int32(newCap))
 	p.lock.Lock()
-	p.cond.Wait() // 阻塞等待其他goroutine通知worker已经执行完，并放回池子
-	idleWorkers := p.workers
-	n := len(idleWorkers) - 1
-	if n < 0 { // 说明不存在空闲worker
-		panic("waitIdleWorker err")
-	}
-	w := idleWorkers[n]
-	idleWorkers[n] = nil
-	p.workers = idleWorkers[:n]
-	p.lock.Unlock()
-	return w
+	defer p.lock.Unlock()
+	if newCap > oldCap {
+		p.cond.Broadcast()
+		return
+	}
+	excess := p.RunningWorkerCount() - newCap
+	for ; excess > 0 && p.workers.len() > 0; excess-- {
+		p.workers.detach().stop() // 通知空闲worker退出
+	}
 }
 
-func (p *Pool) Release() {
-	p.once.Do(func() {
-		p.lock.Lock()
-		workers := p.workers
-		for i, w := range workers {
-			if w == nil {
-				continue
-			}
-			w.task = nil // todo 这里是否要将task执行完再
-			w.pool = nil
-			workers[i] = nil
+// ReleaseTimeout 释放pool并等待正在执行的任务完成，超过timeout返回ErrorReleaseTimeout
+func (p *Pool) ReleaseTimeout(timeout time.Duration) error {
+	p.Release()
+	deadline := p.clock.Now().Add(timeout)
+	ticker := p.clock.NewTicker(releasePollInterval)
+	defer ticker.Stop()
+	for p.RunningWorkerCount() > 0 {
+		if !p.clock.Now().Before(deadline) {
+			return ErrorReleaseTimeout
 		}
-		p.workers = nil
-		p.lock.Unlock()
-		p.release <- sig{}
-	})
+		<-ticker.C()
+	}
+	return nil
+}
+
+// Reboot 重新开启已经释放的pool
+func (p *Pool) Reboot() {
+	if !atomic.CompareAndSwapInt32(&p.state, stateClosed, stateOpened) {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	// Release还没来得及停止清理goroutine时继续沿用
+	if p.stopClear == nil {
+		p.stopClear = make(chan sig)
+		go p.clearExpireWorker(p.stopClear)
+	}
 }
 
 func (p *Pool) IsClosed() bool {
-	return len(p.release) > 0
+	return atomic.LoadInt32(&p.state) == stateClosed
 }
 
 func (p *Pool) RunningWorkerCount() int {
 	return int(atomic.LoadInt32(&p.running))
 }
 
+// FreeWorkerCount 还能接收的任务数量，空闲的worker也算在内，不加锁读取，供负载均衡使用
 func (p *Pool) FreeWorkerCount() int {
-	return int(p.cap - p.running)
+	return p.Cap() - p.RunningWorkerCount() + p.workers.len()
 }
 
 // worker中长时间没有任务，需要将worker清理掉，防止一直占用内存
-func (p *Pool) clearExpireWorker() {
-	ticker := time.NewTicker(p.expire)
+func (p *Pool) clearExpireWorker(stop chan sig) {
+	ticker := p.clock.NewTicker(p.expire)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			if p.IsClosed() {
-				return
-			}
-			//循环空闲的workers 如果当前时间和worker的最后运行任务的时间 差值大于expire 进行清理
+		case <-stop:
+			return
+		case <-ticker.C():
+			// 最后运行任务的时间早于now-expire的worker一次性移出空闲队列，在锁外通知退出
 			p.lock.Lock()
-			idleWorkers := p.workers
-			for i := len(idleWorkers) - 1; i >= 0; i-- {
-				w := idleWorkers[i]
-				diffTime := time.Now().Sub(w.lastTime)
-				if diffTime < p.expire {
-					continue
-				}
-				p.workers[i] = nil
-				p.workers = append(p.workers[:i], p.workers[i+1:]...)
-				log.Printf("worker%d超时%v，已被清理,running:%d, workers:%v \n", i, diffTime, p.running, p.workers)
-			}
-			log.Printf("workers:%v", p.workers)
+			// refresh返回的切片只在清理goroutine中使用，锁外遍历是安全的
+			expired := p.workers.refresh(p.clock.Now().Add(-p.expire))
 			p.lock.Unlock()
+			for i, w := range expired {
+				w.stop() // 通知worker退出
+				expired[i] = nil
+			}
+			if len(expired) > 0 {
+				p.logger.Printf("%d个worker超时%v，已被清理，running:%d", len(expired), p.expire, p.RunningWorkerCount())
+			}
 		}
 	}
 }
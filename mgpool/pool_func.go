@@ -0,0 +1,74 @@
+package mgpool
+
+import (
+	"context"
+	"time"
+)
+
+// PoolWithFunc 绑定同一个函数的pool，每次只提交参数，不需要为每个任务创建闭包
+// worker的生命周期、过期清理和容量控制与Pool一致
+type PoolWithFunc struct {
+	pool *Pool
+}
+
+func NewPoolWithFunc(cap int, fn func(arg any), options ...Option) (*PoolWithFunc, error) {
+	if fn == nil {
+		return nil, ErrorLackPoolFunc
+	}
+	p, err := newPool(cap, fn, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &PoolWithFunc{pool: p}, nil
+}
+
+// Invoke 用arg调用绑定的函数，没有可用worker时的行为与Pool.Submit一致
+func (pf *PoolWithFunc) Invoke(arg any) error {
+	return pf.InvokeContext(context.Background(), arg)
+}
+
+// InvokeContext 没有可用worker时最多等待到ctx结束
+func (pf *PoolWithFunc) InvokeContext(ctx context.Context, arg any) error {
+	w, err := pf.pool.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	w.args <- arg
+	return nil
+}
+
+func (pf *PoolWithFunc) Cap() int {
+	return pf.pool.Cap()
+}
+
+func (pf *PoolWithFunc) Tune(newCap int) {
+	pf.pool.Tune(newCap)
+}
+
+func (pf *PoolWithFunc) RunningWorkerCount() int {
+	return pf.pool.RunningWorkerCount()
+}
+
+func (pf *PoolWithFunc) FreeWorkerCount() int {
+	return pf.pool.FreeWorkerCount()
+}
+
+func (pf *PoolWithFunc) PanicCount() int {
+	return pf.pool.PanicCount()
+}
+
+func (pf *PoolWithFunc) IsClosed() bool {
+	return pf.pool.IsClosed()
+}
+
+func (pf *PoolWithFunc) Release() {
+	pf.pool.Release()
+}
+
+func (pf *PoolWithFunc) ReleaseTimeout(timeout time.Duration) error {
+	return pf.pool.ReleaseTimeout(timeout)
+}
+
+func (pf *PoolWithFunc) Reboot() {
+	pf.pool.Reboot()
+}
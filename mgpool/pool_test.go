@@ -0,0 +1,559 @@
+package mgpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/junaozun/mango/clock"
+)
+
+func TestPoolReuseWorker(t *testing.T) {
+	p, err := NewPool(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	var (
+		wg    sync.WaitGroup
+		count int32
+	)
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		err := p.Submit(func() {
+			defer wg.Done()
+			atomic.AddInt32(&count, 1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := p.RunningWorkerCount(); n > 10 {
+			t.Fatalf("running workers %d exceed cap", n)
+		}
+	}
+	wg.Wait()
+	if count != 1000 {
+		t.Fatalf("want 1000 tasks, got %d", count)
+	}
+}
+
+func TestPoolExpireWorker(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	p, err := New(2, WithExpiry(time.Second), WithClock(clk), WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		p.Submit(func() {
+			time.Sleep(time.Millisecond)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	waitFor(t, func() bool {
+		return p.idleWorkerCount() == 2
+	})
+
+	// 空闲超过expire的worker被清理，goroutine退出
+	clk.Advance(2 * time.Second)
+	waitFor(t, func() bool {
+		return p.RunningWorkerCount() == 0 && p.idleWorkerCount() == 0
+	})
+}
+
+func TestPoolRelease(t *testing.T) {
+	p, _ := NewPool(1)
+	p.Submit(func() {})
+	p.Release()
+	if err := p.Submit(func() {}); err != ErrorPoolHasClosed {
+		t.Fatalf("want ErrorPoolHasClosed, got %v", err)
+	}
+	waitFor(t, func() bool {
+		return p.RunningWorkerCount() == 0
+	})
+}
+
+func (p *Pool) idleWorkerCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.workers.len()
+}
+
+// releaseTimeout 调用ReleaseTimeout，并按轮询间隔推进时钟直到返回
+func releaseTimeout(clk *clock.Fake, p *Pool, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.ReleaseTimeout(timeout)
+	}()
+	for {
+		select {
+		case err := <-errc:
+			return err
+		default:
+			clk.Advance(releasePollInterval)
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolNonblocking(t *testing.T) {
+	p, _ := New(1, WithNonblocking(true))
+	defer p.Release()
+
+	block := make(chan struct{})
+	defer close(block)
+	if err := p.Submit(func() { <-block }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Submit(func() {}); err != ErrPoolOverload {
+		t.Fatalf("want ErrPoolOverload, got %v", err)
+	}
+}
+
+func TestPoolMaxBlockingTasks(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+	p.MaxBlockingTasks = 1
+
+	block := make(chan struct{})
+	p.Submit(func() { <-block })
+	// 第一个等待的调用方阻塞，第二个直接返回
+	blocked := make(chan error)
+	go func() {
+		blocked <- p.Submit(func() {})
+	}()
+	waitFor(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.blocking == 1
+	})
+	if err := p.Submit(func() {}); err != ErrPoolOverload {
+		t.Fatalf("want ErrPoolOverload, got %v", err)
+	}
+	close(block)
+	if err := <-blocked; err != nil {
+		t.Fatalf("want blocked submit to succeed, got %v", err)
+	}
+}
+
+func TestPoolSubmitContext(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	block := make(chan struct{})
+	defer close(block)
+	p.Submit(func() { <-block })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.SubmitContext(ctx, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	p.lock.Lock()
+	blocking := p.blocking
+	p.lock.Unlock()
+	if blocking != 0 {
+		t.Fatalf("want no blocked waiter left, got %d", blocking)
+	}
+}
+
+func TestSubmitFunc(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+	ctx := context.Background()
+
+	futures := make([]Future[int], 0, 10)
+	for i := 0; i < 10; i++ {
+		i := i
+		futures = append(futures, SubmitFunc(p, func() (int, error) {
+			return i * i, nil
+		}))
+	}
+	results, err := All(ctx, futures...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range results {
+		if v != i*i {
+			t.Fatalf("want %d, got %d", i*i, v)
+		}
+	}
+
+	boom := SubmitFunc(p, func() (int, error) {
+		panic("boom")
+	})
+	_, err = boom.Get(ctx)
+	if pe, ok := err.(*PanicError); !ok || pe.Value != "boom" {
+		t.Fatalf("want PanicError, got %v", err)
+	}
+	if _, err := All(ctx, futures[0], boom); err == nil {
+		t.Fatal("want All to fail")
+	}
+	if v, err := Any(ctx, boom, futures[3]); err != nil || v != 9 {
+		t.Fatalf("want 9, got %d %v", v, err)
+	}
+	if _, err := Any[int](ctx); err != ErrorNoFutures {
+		t.Fatalf("want ErrorNoFutures, got %v", err)
+	}
+}
+
+func TestPoolTune(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	block := make(chan struct{})
+	p.Submit(func() { <-block })
+	// 扩容后等待中的调用方可以拿到新的worker
+	submitted := make(chan error)
+	go func() {
+		submitted <- p.Submit(func() { <-block })
+	}()
+	waitFor(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.blocking == 1
+	})
+	p.Tune(2)
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	if n := p.RunningWorkerCount(); n != 2 {
+		t.Fatalf("want 2 running workers, got %d", n)
+	}
+
+	// 缩容后任务完成的worker直接退出
+	p.Tune(1)
+	close(block)
+	waitFor(t, func() bool {
+		return p.RunningWorkerCount() <= 1
+	})
+	if p.Cap() != 1 {
+		t.Fatalf("want cap 1, got %d", p.Cap())
+	}
+}
+
+func TestPoolReleaseTimeoutAndReboot(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	p, _ := New(2, WithClock(clk))
+	var done int32
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		p.Submit(func() {
+			<-block
+			atomic.AddInt32(&done, 1)
+		})
+	}
+	if err := releaseTimeout(clk, p, time.Second); err != ErrorReleaseTimeout {
+		t.Fatalf("want ErrorReleaseTimeout, got %v", err)
+	}
+	// 再次等待时已经释放，只等正在执行的任务完成
+	close(block)
+	if err := releaseTimeout(clk, p, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&done) != 2 {
+		t.Fatalf("want running tasks drained, got %d", done)
+	}
+
+	p.Reboot()
+	if p.IsClosed() {
+		t.Fatal("want pool reopened")
+	}
+	ran := make(chan struct{})
+	if err := p.Submit(func() { close(ran) }); err != nil {
+		t.Fatal(err)
+	}
+	<-ran
+	p.Release()
+}
+
+func TestPoolPanicHandler(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+	type panicked struct {
+		value any
+		stack []byte
+	}
+	got := make(chan panicked, 1)
+	p.PanicHandler = func(v any, stack []byte) {
+		got <- panicked{v, stack}
+	}
+	p.Submit(func() {
+		panic("boom")
+	})
+	pv := <-got
+	if pv.value != "boom" {
+		t.Fatalf("want boom, got %v", pv.value)
+	}
+	if !strings.Contains(string(pv.stack), "TestPoolPanicHandler") {
+		t.Fatalf("want stack to contain the task, got %s", pv.stack)
+	}
+	waitFor(t, func() bool {
+		return p.PanicCount() == 1
+	})
+	// panic的worker退出后pool仍然可以继续使用
+	ran := make(chan struct{})
+	p.Submit(func() { close(ran) })
+	<-ran
+}
+
+func TestPoolWithFunc(t *testing.T) {
+	var (
+		wg  sync.WaitGroup
+		sum int64
+	)
+	pf, err := NewPoolWithFunc(4, func(arg any) {
+		defer wg.Done()
+		atomic.AddInt64(&sum, int64(arg.(int)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Release()
+
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		if err := pf.Invoke(i); err != nil {
+			t.Fatal(err)
+		}
+		if n := pf.RunningWorkerCount(); n > 4 {
+			t.Fatalf("running workers %d exceed cap", n)
+		}
+	}
+	wg.Wait()
+	if sum != 5050 {
+		t.Fatalf("want 5050, got %d", sum)
+	}
+
+	if _, err := NewPoolWithFunc(1, nil); err != ErrorLackPoolFunc {
+		t.Fatalf("want ErrorLackPoolFunc, got %v", err)
+	}
+}
+
+func TestMultiPool(t *testing.T) {
+	for _, lbs := range []LoadBalancingStrategy{RoundRobin, LeastTasks} {
+		mp, err := NewMultiPool(4, 2, lbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mp.Cap() != 8 {
+			t.Fatalf("want cap 8, got %d", mp.Cap())
+		}
+		block := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			mp.Submit(func() {
+				wg.Done()
+				<-block
+			})
+		}
+		wg.Wait()
+		// 任务均匀分布在子pool上，8个任务占满所有worker
+		if n := mp.RunningWorkerCount(); n != 8 {
+			t.Fatalf("want 8 running workers, got %d", n)
+		}
+		if n := mp.FreeWorkerCount(); n != 0 {
+			t.Fatalf("want 0 free workers, got %d", n)
+		}
+		close(block)
+		if err := mp.ReleaseTimeout(time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if !mp.IsClosed() {
+			t.Fatal("want closed")
+		}
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	p, _ := New(2, WithNonblocking(true), WithPanicHandler(func(any, []byte) {}))
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	p.Submit(func() { wg.Done() })
+	p.Submit(func() {
+		defer wg.Done()
+		panic("boom")
+	})
+	wg.Wait()
+	waitFor(t, func() bool {
+		s := p.Stats()
+		return s.Completed == 1 && s.Panicked == 1 && s.Running == 1
+	})
+
+	block := make(chan struct{})
+	p.Submit(func() { <-block })
+	p.Submit(func() { <-block })
+	if err := p.Submit(func() {}); err != ErrPoolOverload {
+		t.Fatalf("want ErrPoolOverload, got %v", err)
+	}
+	s := p.Stats()
+	if s.Submitted != 4 || s.Rejected != 1 {
+		t.Fatalf("want 4 submitted 1 rejected, got %+v", s)
+	}
+	if s.Free != 0 || s.Idle != 0 {
+		t.Fatalf("want no free worker, got %+v", s)
+	}
+	if s.QueueWait.Count != 4 {
+		t.Fatalf("want 4 queue wait samples, got %d", s.QueueWait.Count)
+	}
+	close(block)
+	waitFor(t, func() bool {
+		s := p.Stats()
+		// 空闲的worker同样可以接收任务
+		return s.Idle == 2 && s.Free == 2 && s.Execution.Count == 3
+	})
+}
+
+func TestWorkerStackRefresh(t *testing.T) {
+	ws := newWorkerStack(0)
+	now := time.Unix(100, 0)
+	for i := 0; i < 10; i++ {
+		ws.insert(&Worker{lastTime: now.Add(time.Duration(i) * time.Second)})
+	}
+	// 最后使用时间早于now+4s的4个worker过期
+	expired := ws.refresh(now.Add(4 * time.Second))
+	if len(expired) != 4 || ws.len() != 6 {
+		t.Fatalf("want 4 expired 6 left, got %d %d", len(expired), ws.len())
+	}
+	if w := ws.detach(); !w.lastTime.Equal(now.Add(9 * time.Second)) {
+		t.Fatalf("want most recently used worker, got %v", w.lastTime)
+	}
+	if expired := ws.refresh(now); len(expired) != 0 {
+		t.Fatalf("want nothing expired, got %d", len(expired))
+	}
+}
+
+func TestGroup(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	var count int32
+	g, _ := NewGroup(context.Background(), p)
+	for i := 0; i < 10; i++ {
+		g.Go(func(ctx context.Context) error {
+			atomic.AddInt32(&count, 1)
+			if n := p.RunningWorkerCount(); n > 2 {
+				t.Errorf("running workers %d exceed cap", n)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Fatalf("want 10 tasks, got %d", count)
+	}
+
+	errFirst := errors.New("first")
+	g, ctx := NewGroup(context.Background(), p)
+	g.Go(func(ctx context.Context) error {
+		return errFirst
+	})
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done() // 第一个错误取消ctx
+		return ctx.Err()
+	})
+	if err := g.Wait(); err != errFirst {
+		t.Fatalf("want first error, got %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("want derived ctx canceled")
+	}
+}
+
+func TestMultiPoolLeastTasksWarm(t *testing.T) {
+	mp, err := NewMultiPool(4, 2, LeastTasks, WithNonblocking(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mp.Release()
+
+	// 先让所有子pool的worker都创建出来并回到空闲队列
+	var wg sync.WaitGroup
+	wg.Add(8)
+	block := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		if err := mp.Submit(func() {
+			wg.Done()
+			<-block
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	close(block)
+	waitFor(t, func() bool {
+		return mp.Stats().Idle == 8
+	})
+
+	// 预热后任务仍然分散到各个子pool，不会全部落到第一个子pool上
+	block = make(chan struct{})
+	defer close(block)
+	wg.Add(8)
+	for i := 0; i < 8; i++ {
+		if err := mp.Submit(func() {
+			wg.Done()
+			<-block
+		}); err != nil {
+			t.Fatalf("submit %d: %v", i, err)
+		}
+	}
+	wg.Wait()
+	for i, p := range mp.pools {
+		if n := p.FreeWorkerCount(); n != 0 {
+			t.Fatalf("want sub-pool %d fully busy, got %d free", i, n)
+		}
+	}
+}
+
+func TestPoolReleaseRebootConcurrent(t *testing.T) {
+	p, _ := NewPool(2)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.Release()
+		}()
+		go func() {
+			defer wg.Done()
+			p.Reboot()
+		}()
+	}
+	wg.Wait()
+	p.Reboot()
+	ran := make(chan struct{})
+	if err := p.Submit(func() { close(ran) }); err != nil {
+		t.Fatal(err)
+	}
+	<-ran
+	if err := p.ReleaseTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,150 @@
+package mgpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds 耗时直方图的桶上界，最后一个桶统计超过10s的任务
+var latencyBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// Histogram 耗时分布，Counts[i]为耗时<=Bounds[i]的数量，最后一个元素为超过所有上界的数量
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+func (h *Histogram) merge(o Histogram) {
+	if h.Counts == nil {
+		h.Bounds = o.Bounds
+		h.Counts = make([]uint64, len(o.Counts))
+	}
+	for i := range o.Counts {
+		h.Counts[i] += o.Counts[i]
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
+
+// Mean 平均耗时
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+type histogram struct {
+	counts [7]uint64 // len(latencyBounds)+1
+	count  uint64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	counts := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return Histogram{
+		Bounds: latencyBounds,
+		Counts: counts,
+		Count:  atomic.LoadUint64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+}
+
+type metrics struct {
+	submitted uint64
+	completed uint64
+	panicked  uint64
+	rejected  uint64
+	queueWait histogram // 提交任务时等待worker的耗时
+	execution histogram // 任务执行耗时
+}
+
+// Stats pool某一时刻的统计快照
+type Stats struct {
+	Cap       int
+	Running   int // 存活的worker数量，包括空闲的
+	Idle      int // 空闲的worker数量
+	Free      int // 还能接收的任务数量：cap - 正在执行任务的worker数量
+	Blocking  int // 阻塞等待worker的调用方数量
+	Submitted uint64
+	Completed uint64
+	Panicked  uint64
+	Rejected  uint64 // 因为pool已释放、过载或ctx结束而提交失败的任务数量
+	QueueWait Histogram
+	Execution Histogram
+}
+
+func (s *Stats) merge(o Stats) {
+	s.Cap += o.Cap
+	s.Running += o.Running
+	s.Idle += o.Idle
+	s.Free += o.Free
+	s.Blocking += o.Blocking
+	s.Submitted += o.Submitted
+	s.Completed += o.Completed
+	s.Panicked += o.Panicked
+	s.Rejected += o.Rejected
+	s.QueueWait.merge(o.QueueWait)
+	s.Execution.merge(o.Execution)
+}
+
+// done 任务正常执行完成
+func (p *Pool) done(start time.Time) {
+	p.metrics.execution.observe(p.clock.Since(start))
+	atomic.AddUint64(&p.metrics.completed, 1)
+}
+
+func (p *Pool) Stats() Stats {
+	p.lock.Lock()
+	idle, blocking := p.workers.len(), p.blocking
+	p.lock.Unlock()
+	running := p.RunningWorkerCount()
+	return Stats{
+		Cap:       p.Cap(),
+		Running:   running,
+		Idle:      idle,
+		Free:      p.Cap() - running + idle,
+		Blocking:  blocking,
+		Submitted: atomic.LoadUint64(&p.metrics.submitted),
+		Completed: atomic.LoadUint64(&p.metrics.completed),
+		Panicked:  atomic.LoadUint64(&p.metrics.panicked),
+		Rejected:  atomic.LoadUint64(&p.metrics.rejected),
+		QueueWait: p.metrics.queueWait.snapshot(),
+		Execution: p.metrics.execution.snapshot(),
+	}
+}
+
+func (pf *PoolWithFunc) Stats() Stats {
+	return pf.pool.Stats()
+}
+
+// Stats 所有子pool统计之和
+func (mp *MultiPool) Stats() Stats {
+	var s Stats
+	for _, p := range mp.pools {
+		s.merge(p.Stats())
+	}
+	return s
+}
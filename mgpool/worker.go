@@ -1,37 +1,100 @@
 package mgpool
 
 import (
-	"log"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
 type Worker struct {
 	pool     *Pool
 	task     chan func()
+	args     chan any  // PoolWithFunc的worker通过args接收参数
 	lastTime time.Time // 执行任务的最后时间
 }
 
+// exitSignal PoolWithFunc的worker收到后退出，nil是合法的参数不能作为退出信号
+type exitSignal struct{}
+
+// stop 通知空闲的worker退出
+func (w *Worker) stop() {
+	if w.args != nil {
+		w.args <- exitSignal{}
+		return
+	}
+	w.task <- nil
+}
+
+// run 启动worker的goroutine，worker会一直循环执行任务，直到过期被清理或者pool被释放
 func (w *Worker) run() {
 	w.pool.incRunning()
 	go w.running()
 }
 
 func (w *Worker) running() {
+	var current any
+	if w.args != nil {
+		current = w.pool.fn
+	}
 	defer func() {
-		w.pool.PutWorker(w) // 任务运行完成，需要处理的事情
+		// 先recover再归还worker，保证PanicHandler拿到的是当前任务的调用栈
 		if err := recover(); err != nil {
+			atomic.AddUint64(&w.pool.metrics.panicked, 1)
+			stack := debug.Stack()
 			if w.pool.PanicHandler != nil {
-				w.pool.PanicHandler()
+				w.pool.PanicHandler(err, stack)
 			} else {
-				log.Println(err)
+				w.pool.logger.Printf("task %s panic: %v\n%s", funcName(current), err, stack)
 			}
 		}
+		w.pool.decRunning()
+		w.pool.workerCache.Put(w)
+		// worker退出，通知等待的goroutine可以新建worker
+		w.pool.lock.Lock()
+		w.pool.cond.Signal()
+		w.pool.lock.Unlock()
 	}()
+	if w.args != nil {
+		w.invoke()
+		return
+	}
 	for f := range w.task {
-		if f == nil {
+		if f == nil { // 收到nil说明worker需要退出
 			return
 		}
+		current = f
+		start := w.pool.clock.Now()
 		f()
-		return
+		w.pool.done(start)
+		if ok := w.pool.PutWorker(w); !ok {
+			return
+		}
+	}
+}
+
+func (w *Worker) invoke() {
+	for arg := range w.args {
+		if _, ok := arg.(exitSignal); ok {
+			return
+		}
+		start := w.pool.clock.Now()
+		w.pool.fn(arg)
+		w.pool.done(start)
+		if ok := w.pool.PutWorker(w); !ok {
+			return
+		}
+	}
+}
+
+func funcName(f any) string {
+	if f == nil || reflect.ValueOf(f).IsNil() {
+		return "unknown"
+	}
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
 	}
+	return fn.Name()
 }
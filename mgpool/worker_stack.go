@@ -0,0 +1,81 @@
+package mgpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// workerStack 空闲worker队列，按放回的时间先后排列，末尾是最近使用过的worker
+// 取worker时从末尾取，清理过期worker时二分查找过期的分界点，一次性切掉头部
+// 修改需要持有pool的锁，len可以不加锁读取
+type workerStack struct {
+	items   []*Worker
+	expired []*Worker // 复用的过期worker切片，避免每次清理都分配
+	size    int32     // 空闲worker数量，原子更新
+}
+
+func newWorkerStack(size int) *workerStack {
+	return &workerStack{
+		items: make([]*Worker, 0, size),
+	}
+}
+
+func (ws *workerStack) len() int {
+	return int(atomic.LoadInt32(&ws.size))
+}
+
+func (ws *workerStack) insert(w *Worker) {
+	ws.items = append(ws.items, w)
+	atomic.AddInt32(&ws.size, 1)
+}
+
+// detach 取出最近使用过的worker，队列为空时返回nil
+func (ws *workerStack) detach() *Worker {
+	n := len(ws.items) - 1
+	if n < 0 {
+		return nil
+	}
+	w := ws.items[n]
+	ws.items[n] = nil // 置空，防止内存泄漏
+	ws.items = ws.items[:n]
+	atomic.AddInt32(&ws.size, -1)
+	return w
+}
+
+// refresh 移除最后使用时间早于deadline的worker并返回，返回的切片在下次refresh前有效
+func (ws *workerStack) refresh(deadline time.Time) []*Worker {
+	n := ws.search(deadline)
+	if n == 0 {
+		return nil
+	}
+	ws.expired = append(ws.expired[:0], ws.items[:n]...)
+	m := copy(ws.items, ws.items[n:])
+	for i := m; i < len(ws.items); i++ {
+		ws.items[i] = nil
+	}
+	ws.items = ws.items[:m]
+	atomic.StoreInt32(&ws.size, int32(m))
+	return ws.expired
+}
+
+// search 二分查找第一个最后使用时间不早于deadline的worker下标，即过期worker的数量
+func (ws *workerStack) search(deadline time.Time) int {
+	l, r := 0, len(ws.items)
+	for l < r {
+		mid := int(uint(l+r) >> 1)
+		if ws.items[mid].lastTime.Before(deadline) {
+			l = mid + 1
+		} else {
+			r = mid
+		}
+	}
+	return l
+}
+
+// reset 清空队列并返回所有空闲worker
+func (ws *workerStack) reset() []*Worker {
+	items := ws.items
+	ws.items = make([]*Worker, 0, cap(items))
+	atomic.StoreInt32(&ws.size, 0)
+	return items
+}
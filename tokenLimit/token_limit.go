@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
+	"github.com/junaozun/mango/clock"
 	xrate "golang.org/x/time/rate"
 	"log"
 	"strconv"
@@ -28,11 +29,16 @@ type TokenLimiter struct {
 	execScript    *redis.Script  // 执行脚本
 	client        *redis.Client
 	iMgr          ITokenLimiterMgr
+	clock         clock.Clock
 }
 
 // NewTokenLimiter returns a new TokenLimiter that allows events up to rate and permits
 // bursts of at most burst tokens.
 func NewTokenLimiter(rate, burst int, key string, client *redis.Client, iMgr ITokenLimiterMgr) *TokenLimiter {
+	clk := clock.New()
+	if cp, ok := iMgr.(clockProvider); ok {
+		clk = cp.GetClock()
+	}
 	return &TokenLimiter{
 		rate:          rate,
 		burst:         burst,
@@ -42,12 +48,13 @@ func NewTokenLimiter(rate, burst int, key string, client *redis.Client, iMgr ITo
 		timestampKey:  fmt.Sprintf(timestampFormat, key),
 		rescueLimiter: xrate.NewLimiter(xrate.Every(time.Second/time.Duration(rate)), burst),
 		iMgr:          iMgr,
+		clock:         clk,
 	}
 }
 
-// Allow is shorthand for AllowN(time.Now(), 1).
+// Allow is shorthand for AllowN(clock.Now(), 1).
 func (t *TokenLimiter) Allow() bool {
-	return t.AllowN(time.Now(), 1)
+	return t.AllowN(t.clock.Now(), 1)
 }
 
 func (t *TokenLimiter) AllowN(now time.Time, n int) bool {
@@ -2,9 +2,9 @@ package tokenLimit
 
 import (
 	"github.com/go-redis/redis"
+	"github.com/junaozun/mango/clock"
 	"sync"
 	"sync/atomic"
-	"time"
 )
 
 type ITokenLimiterMgr interface {
@@ -12,6 +12,11 @@ type ITokenLimiterMgr interface {
 	StartMonitor()
 }
 
+// clockProvider ITokenLimiterMgr可选实现，提供限流器使用的时间来源，未实现时使用真实时间
+type clockProvider interface {
+	GetClock() clock.Clock
+}
+
 // TokenLimiterMgr 接口限流管理器
 type TokenLimiterMgr struct {
 	client         *redis.Client
@@ -20,14 +25,21 @@ type TokenLimiterMgr struct {
 	monitorStarted bool
 	apiTokenLock   sync.Mutex
 	apiTokenLimits map[string]*TokenLimiter // api uniqueKey ---> tokenLimiter
+	clock          clock.Clock
 }
 
 func NewTokenLimiterMgr(client *redis.Client) *TokenLimiterMgr {
+	return NewTokenLimiterMgrWithClock(client, clock.New())
+}
+
+// NewTokenLimiterMgrWithClock 指定时间来源，测试中可以传入clock.Fake控制令牌的补充
+func NewTokenLimiterMgrWithClock(client *redis.Client, clk clock.Clock) *TokenLimiterMgr {
 	return &TokenLimiterMgr{
 		client:         client,
 		redisAlive:     1,
 		monitorStarted: false,
 		apiTokenLimits: make(map[string]*TokenLimiter),
+		clock:          clk,
 	}
 }
 
@@ -47,6 +59,10 @@ func (m *TokenLimiterMgr) GetRedisAlive() *uint32 {
 	return &m.redisAlive
 }
 
+func (m *TokenLimiterMgr) GetClock() clock.Clock {
+	return m.clock
+}
+
 func (m *TokenLimiterMgr) StartMonitor() {
 	m.rescueLock.Lock()
 	defer m.rescueLock.Unlock()
@@ -62,7 +78,7 @@ func (m *TokenLimiterMgr) StartMonitor() {
 }
 
 func (m *TokenLimiterMgr) waitForRedis() {
-	ticker := time.NewTicker(pingInterval)
+	ticker := m.clock.NewTicker(pingInterval)
 	defer func() {
 		ticker.Stop()
 		m.rescueLock.Lock()
@@ -70,7 +86,7 @@ func (m *TokenLimiterMgr) waitForRedis() {
 		m.rescueLock.Unlock()
 	}()
 
-	for range ticker.C {
+	for range ticker.C() {
 		if m.Ping() {
 			atomic.StoreUint32(&m.redisAlive, 1)
 			return
@@ -3,6 +3,7 @@ package tokenLimit
 import (
 	"fmt"
 	"github.com/go-redis/redis"
+	"github.com/junaozun/mango/clock"
 	"net/http"
 	"testing"
 	"time"
@@ -10,7 +11,7 @@ import (
 
 func TestTokenLimit(t *testing.T) {
 	const (
-		total = 100000
+		total = 100
 		rate  = 10
 		burst = 10
 	)
@@ -21,19 +22,33 @@ func TestTokenLimit(t *testing.T) {
 	client := redis.NewClient(&redis.Options{
 		Addr: "127.0.0.1:6379",
 	})
-	mgr := NewTokenLimiterMgr(client)
+	clk := clock.NewFake(time.Now())
+	mgr := NewTokenLimiterMgrWithClock(client, clk)
 	l := mgr.GetOrCreateTokenLimiter(rate, burst, "sdkDentalSdkMedicalRecordAdd")
+	// 时间不推进时最多放行burst个请求
 	var allowed int
+	for i := 0; i < burst*2; i++ {
+		if l.Allow() {
+			allowed++
+		}
+	}
+	if allowed != burst {
+		t.Fatalf("want %d allowed without refill, got %d", burst, allowed)
+	}
+
+	// 每次推进1秒，令牌桶补满，请求全部放行
+	allowed = 0
 	for i := 0; i < total; i++ {
-		time.Sleep(time.Millisecond * 1000)
+		clk.Advance(time.Second)
 		if l.Allow() {
 			allowed++
-			fmt.Printf("---------------allowed---------------:%v\n", allowed)
 		} else {
 			fmt.Printf("limit:%v\n", allowed)
 		}
 	}
-
+	if allowed != total {
+		t.Fatalf("want %d allowed, got %d", total, allowed)
+	}
 }
 
 func GetApi() {